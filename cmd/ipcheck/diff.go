@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/anrid/ipcheck/pkg/iputil"
+)
+
+func runDiff(args []string) {
+	flags := newFlagSet("diff", "ipcheck diff [flags] <old file> <new file>", "Shows the IPs added to and removed from each list between two FireHOL files or ranges CSV files, e.g. before and after `ipcheck update`. Exits with 1 if there are any differences.")
+
+	typ := flags.String("type", "", fmt.Sprintf("Type of both files: %s (default: %s for *.csv files, %s for *.json files, else %s).", strings.Join(ipcheck.SourceTypes, ", "), ipcheck.SourceRangesCSV, ipcheck.SourceCloudJSON, ipcheck.SourceFireHOL))
+	details := flags.Bool("details", false, "Show all CIDRs added and removed.")
+	asJSON := flags.Bool("json", false, "Output the differences as JSON.")
+
+	parseFlags(flags, args)
+
+	if flags.NArg() != 2 {
+		flags.Usage()
+		os.Exit(exitError)
+	}
+
+	var loaded [2][]ipcheck.Range
+	for i, file := range flags.Args() {
+		t := *typ
+		if t == "" {
+			switch {
+			case strings.HasSuffix(file, ".csv"):
+				t = ipcheck.SourceRangesCSV
+			case strings.HasSuffix(file, ".json"):
+				t = ipcheck.SourceCloudJSON
+			default:
+				t = ipcheck.SourceFireHOL
+			}
+		}
+
+		var err error
+		loaded[i], err = ipcheck.LoadRanges("", "", ipcheck.Source{Name: "diff", Type: t, Path: file})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load %s: %s\n", file, err)
+			os.Exit(exitError)
+		}
+	}
+
+	diffs := ipcheck.Diff(loaded[0], loaded[1])
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(diffs)
+	} else {
+		for _, d := range diffs {
+			fmt.Printf("%-8s %-40s +%d -%d IPs\n", d.Status, d.List, d.AddedIPs, d.RemovedIPs)
+			if *details {
+				printCIDRs("+", d.Added)
+				printCIDRs("-", d.Removed)
+			}
+		}
+		fmt.Printf("\n%d lists changed\n", len(diffs))
+	}
+
+	if len(diffs) > 0 {
+		os.Exit(exitMatches)
+	}
+}
+
+func printCIDRs(prefix string, ranges []iputil.IPRange) {
+	for _, c := range iputil.RangesToCIDRs(ranges) {
+		fmt.Printf("  %s %s\n", prefix, c)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/anrid/ipcheck/pkg/firehol"
+)
+
+func runDownload(args []string) {
+	flags := newFlagSet("download", "ipcheck download [flags] [dir]", "Downloads all blocklists from the FireHOL repo (https://github.com/firehol/blocklist-ipsets) and merges them into one big file named `firehol.ips` in the given dir.")
+
+	dir := flags.String("dir", "", "Dir to download to (may also be given as an argument).")
+	force := flags.Bool("force", false, "Force (re)download of all FireHOL blocklists (will delete locally cached files).")
+
+	parseFlags(flags, args)
+
+	download(dirArg(flags.Args(), *dir, flags.Usage), *force)
+}
+
+func runUpdate(args []string) {
+	flags := newFlagSet("update", "ipcheck update [flags] [dir]", "Downloads the latest blocklists from the FireHOL repo, replacing any downloaded before, and merges them into `firehol.ips` in the given dir.")
+
+	dir := flags.String("dir", "", "Dir to download to (may also be given as an argument).")
+
+	parseFlags(flags, args)
+
+	download(dirArg(flags.Args(), *dir, flags.Usage), true)
+}
+
+// dirArg returns the dir given as an argument, or else by --dir.
+func dirArg(args []string, dir string, usage func()) string {
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	if dir == "" || len(args) > 1 {
+		usage()
+		os.Exit(exitError)
+	}
+	return dir
+}
+
+func download(dir string, force bool) {
+	err := firehol.Download(dir, force)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Download failed: %s\n", err)
+		os.Exit(exitError)
+	}
+}
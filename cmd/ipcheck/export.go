@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/anrid/ipcheck/pkg/export"
+)
+
+func runExport(args []string) {
+	flags := newFlagSet("export", "ipcheck export [flags]", "Exports all sources to firewall, web server or MMDB formats.")
+
+	format := flags.String("format", "mmdb", fmt.Sprintf("Export format (%s)", strings.Join(export.Formats, ", ")))
+	ipRangesFileOrURL := flags.String("ip-ranges", defaultIPRangesURL, "Path or URL to a CSV file with IP ranges to export.")
+	fireHOLFile := flags.StringP("firehol-file", "f", "", "Path to a `firehol.ips` file created with `ipcheck download`, exports all FireHOL blocklists it contains.")
+	plainLists := listFlag(flags)
+	output := flags.StringP("output", "o", "", "Write the export to this file instead of stdout.")
+	lists := flags.StringSlice("lists", nil, "Only export ranges from lists (vendors or FireHOL IP sets) matching these patterns, e.g. --lists AWS,firehol_level*")
+	allow := flags.StringSlice("allow", nil, "Remove all IPs covered by lists matching these patterns from the export, e.g. --allow office,vpn")
+	name := flags.String("name", "", "Name of the generated ipset, nftables set, iptables chain or pf table (default \"ipcheck\")")
+	verbose := flags.Bool("verbose", false, "Verbose output, helps when troubleshooting.")
+
+	c := parseFlags(flags, args)
+	sources := configSources(c, flags, ipRangesFileOrURL, *plainLists)
+
+	err := export.Export(export.Params{
+		Format:               *format,
+		IPRangesCSVFileOrURL: *ipRangesFileOrURL,
+		FireHOLFile:          *fireHOLFile,
+		Sources:              sources,
+		OutputFile:           *output,
+		Lists:                *lists,
+		Allow:                *allow,
+		Name:                 *name,
+		VerboseOutput:        *verbose,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %s\n", err)
+		os.Exit(exitError)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anrid/ipcheck/pkg/config"
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/spf13/pflag"
+)
+
+// newFlagSet returns the flag set of a command, with a --config flag and a
+// usage message showing the given synopsis and description.
+func newFlagSet(name, synopsis, description string) *pflag.FlagSet {
+	flags := pflag.NewFlagSet(name, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s\n\n%s\n\n", synopsis, description)
+		flags.PrintDefaults()
+	}
+
+	flags.String("config", "", fmt.Sprintf("Path to a config file (default %q if it exists). Env: %s", config.DefaultFile, config.EnvVar("config")))
+
+	return flags
+}
+
+// parseFlags parses the command line, then sets all flags not given on it
+// from their environment variable (see config.EnvVar) or the config file, in
+// that order of precedence. Returns the config file.
+func parseFlags(flags *pflag.FlagSet, args []string) *config.Config {
+	flags.Parse(args)
+
+	configFile, _ := flags.GetString("config")
+	if configFile == "" {
+		configFile = os.Getenv(config.EnvVar("config"))
+	}
+
+	c, err := config.Load(configFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(exitError)
+	}
+	values := c.FlagValues()
+
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == "config" {
+			return
+		}
+
+		value, found := os.LookupEnv(config.EnvVar(f.Name))
+		if !found {
+			value, found = values[f.Name]
+		}
+		if !found {
+			return
+		}
+
+		if err := flags.Set(f.Name, value); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid value for --%s: %s\n", f.Name, err)
+			os.Exit(exitError)
+		}
+	})
+
+	return c
+}
+
+// configSources returns the sources in the config file, followed by the
+// plain lists given with --list (see listFlag). If the config file has any
+// sources, the IP ranges CSV is only loaded in addition to them if
+// --ip-ranges was given (or set by its environment variable).
+func configSources(c *config.Config, flags *pflag.FlagSet, ipRangesFileOrURL *string, lists []string) []ipcheck.Source {
+	if len(c.Sources) > 0 && !flags.Changed("ip-ranges") {
+		*ipRangesFileOrURL = ""
+	}
+
+	sources := append([]ipcheck.Source(nil), c.Sources...)
+	for _, l := range lists {
+		sources = append(sources, listSource(l))
+	}
+	return sources
+}
+
+// listFlag adds the --list flag, loading plain lists of CIDRs, IPs and IP
+// ranges in addition to all other sources.
+func listFlag(flags *pflag.FlagSet) *[]string {
+	return flags.StringArray("list", nil, "Also load a plain list of CIDRs, IPs and IP ranges (a.b.c.d-e.f.g.h), one per line, given as name=path or just path to name it after the file, e.g. --list office=./office.txt. Can be repeated.")
+}
+
+// listSource returns the source of a --list value, name=path or just a path.
+func listSource(value string) ipcheck.Source {
+	name, path, found := strings.Cut(value, "=")
+	if !found || strings.ContainsAny(name, "/:") {
+		// A path or URL (which may contain "=" itself).
+		name, path = strings.TrimSuffix(filepath.Base(value), filepath.Ext(value)), value
+	}
+	return ipcheck.Source{Name: name, Type: ipcheck.SourceList, Path: path}
+}
+
+// filterFlags adds the flags selecting matches by their source, returning a
+// function returning the filter given by them.
+func filterFlags(flags *pflag.FlagSet) func() ipcheck.MatchFilter {
+	categories := flags.StringSlice("category", nil, "Only show matches from sources in these categories, e.g. --category threat,internal")
+	minSeverity := flags.String("min-severity", "", fmt.Sprintf("Only show matches from sources with at least this severity (%s).", strings.Join(ipcheck.Severities, ", ")))
+
+	return func() ipcheck.MatchFilter {
+		return ipcheck.MatchFilter{Categories: *categories, MinSeverity: *minSeverity}
+	}
+}
@@ -1,41 +1,153 @@
 package main
 
 import (
+	"fmt"
 	"os"
 
-	"github.com/anrid/ipcheck/pkg/firehol"
 	"github.com/anrid/ipcheck/pkg/ipcheck"
-	"github.com/spf13/pflag"
 )
 
+const defaultIPRangesURL = "https://raw.githubusercontent.com/jhassine/server-ip-addresses/master/data/datacenters.csv"
+
+// Exit codes.
+const (
+	exitNoMatches = 0
+	exitMatches   = 1
+	exitError     = 2
+)
+
+// command is a subcommand, run with all arguments following its name.
+type command struct {
+	name  string
+	about string
+	run   func(args []string)
+}
+
+var commands = []command{
+	{"check", "Check all IPs found in a file against all sources (the default)", runCheck},
+	{"lookup", "Look up single IPs and CIDRs", runLookup},
+	{"shell", "Look up IPs interactively", runShell},
+	{"download", "Download all FireHOL blocklists", runDownload},
+	{"update", "Download the latest FireHOL blocklists, replacing any downloaded before", runUpdate},
+	{"export", "Export all sources to firewall, web server or MMDB formats", runExport},
+	{"serve", "Serve lookups over HTTP", runServe},
+	{"stats", "Show all lists loaded from the sources", runStats},
+	{"diff", "Show IPs added to and removed from each list between two downloads", runDiff},
+}
+
 func main() {
-	inputFileOrURL := pflag.StringP("input-file", "i", "", "Path or URL to an input file containing IP addresses to check. This can be an uncompressed text file in any format. The program finds all IPs addresses on each line and tests them against all ranges.")
-	ipRangesFileOrURL := pflag.String("ip-ranges", "https://raw.githubusercontent.com/jhassine/server-ip-addresses/master/data/datacenters.csv", "Path or URL to a CSV file with IP ranges to test against.")
-	downloadFireHOLTo := pflag.String("download", "", "Download all blocklists from FileHOL repo (https://github.com/firehol/blocklist-ipsets) and merge them into one big file named `firehol.ips` in this dir")
-	forceDownloadFireHOL := pflag.Bool("force-download", false, "Force (re)download of all FileHOL blocklists (will delete locally cached files)")
-	fireHOLFile := pflag.StringP("firehol-file", "f", "", "Import all IP sets from https://github.com/firehol/blocklist-ipsets, merge them into one CSV file in this dir")
-	verbose := pflag.Bool("verbose", false, "Verbose output, helps when troubleshooting.")
-	showMore := pflag.Bool("more-info", true, "Show additional blocklist info for each IP match.")
-	toCSVFile := pflag.String("to-csv-file", "", "Export all matched IPs to the given CSV file (e.g. ./matches.csv)")
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(exitError)
+	}
+
+	switch os.Args[1] {
+	case "help", "-h", "--help":
+		usage()
+		return
+	}
 
-	pflag.Parse()
+	for _, cmd := range commands {
+		if os.Args[1] == cmd.name {
+			cmd.run(os.Args[2:])
+			return
+		}
+	}
+
+	// No subcommand given, which is how ipcheck used to be run: check, or
+	// download with --download.
+	runCheck(os.Args[1:])
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: ipcheck <command> [flags]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.about)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun `ipcheck <command> --help` to see the flags of a command.\n")
+}
+
+func runCheck(args []string) {
+	flags := newFlagSet("check", "ipcheck check [flags]", "Checks all IPs found in the input file against all sources.")
+
+	inputFileOrURL := flags.StringP("input-file", "i", "", "Path or URL to an input file containing IP addresses to check. This can be an uncompressed text file in any format. The program finds all IPs addresses on each line and tests them against all ranges.")
+	ipRangesFileOrURL := flags.String("ip-ranges", defaultIPRangesURL, "Path or URL to a CSV file with IP ranges to test against.")
+	fireHOLFile := flags.StringP("firehol-file", "f", "", "Path to a `firehol.ips` file created with `ipcheck download`, to also check IPs against all FireHOL blocklists it contains.")
+	lists := listFlag(flags)
+	allow := flags.StringSlice("allow", nil, "Never report IPs found in lists (vendors or FireHOL IP sets) matching these patterns, e.g. --allow office,vpn")
+	verbose := flags.Bool("verbose", false, "Verbose output, helps when troubleshooting.")
+	showMore := flags.Bool("more-info", true, "Show additional blocklist info for each IP match.")
+	cache := flags.Bool("cache", false, "Cache the data loaded from the FireHOL file next to it (as <file>.cache), and reuse it for as long as the FireHOL file doesn't change.")
+	index := flags.String("index", "tree", "Structure used to look up IP ranges: `tree` (interval tree), `flat` (immutable flat array, faster for large datasets) or `radix` (CIDR trie).")
+	match := flags.String("match", "first", "Which range(s) to report when an IP is covered by more than one: `first`, narrowest (the most specific range, like a longest prefix match), widest, priority (the narrowest range from the highest ranked sources) or all (ranked the same way).")
+	filter := filterFlags(flags)
+	batch := flags.Bool("batch", false, "Read all IPs from the input file before checking them in one go. Faster for large files, but no matches are shown until the whole file has been read.")
+	unique := flags.Bool("unique", false, "Report each matched IP only once, with the number of times it was found, after the whole input file has been read.")
+	report := flags.Bool("report", false, "Show a report with match counts per list, category and subnet, the most frequently matched IPs and the share of traffic from datacenters.")
+	reportFile := flags.String("report-file", "", "Write the report as JSON to the given file (e.g. ./report.json)")
+	reportTopN := flags.Int("top", 10, "Number of most frequently matched IPs and subnets to include in the report.")
+	toCSVFile := flags.String("to-csv-file", "", "Export all matched IPs to the given CSV file (e.g. ./matches.csv)")
+	failIfMatches := flags.Int("fail-if-matches", 0, "Only exit with code 1 (matches found) if there are at least this many matches.")
+	failIfRatio := flags.Float64("fail-if-ratio", 0, "Only exit with code 1 (matches found) if at least this share of all IPs checked matched, e.g. 0.05 for 5%.")
+
+	// Kept for compatibility, see `ipcheck download`.
+	downloadFireHOLTo := flags.String("download", "", "Download all FireHOL blocklists to this dir")
+	forceDownloadFireHOL := flags.Bool("force-download", false, "Force (re)download of all FireHOL blocklists")
+	flags.MarkDeprecated("download", "use `ipcheck download --dir <dir>` instead")
+	flags.MarkDeprecated("force-download", "use `ipcheck update --dir <dir>` instead")
+
+	c := parseFlags(flags, args)
+	sources := configSources(c, flags, ipRangesFileOrURL, *lists)
 
 	if *downloadFireHOLTo != "" {
-		firehol.Download(*downloadFireHOLTo, *forceDownloadFireHOL /* force download latest data from the Firehol Github repo */)
-		os.Exit(0)
+		download(*downloadFireHOLTo, *forceDownloadFireHOL)
+		return
 	}
 
-	if *inputFileOrURL == "" || *ipRangesFileOrURL == "" {
-		pflag.Usage()
-		os.Exit(-1)
+	if *inputFileOrURL == "" || *ipRangesFileOrURL == "" && len(sources) == 0 {
+		flags.Usage()
+		os.Exit(exitError)
 	}
 
-	ipcheck.CheckAgainstIPRanges(ipcheck.CheckAgainstIPRangesParams{
+	res, err := ipcheck.Check(ipcheck.CheckAgainstIPRangesParams{
 		InputFileORURL:              *inputFileOrURL,
 		IPRangesCSVFileOrURL:        *ipRangesFileOrURL,
 		FireHOLFile:                 *fireHOLFile,
+		Sources:                     sources,
+		Filter:                      filter(),
+		Scoring:                     c.Scoring,
 		VerboseOutput:               *verbose,
 		ShowAdditionalBlocklistInfo: *showMore,
 		ToCSVFile:                   *toCSVFile,
+		Index:                       *index,
+		Batch:                       *batch,
+		Match:                       *match,
+		Unique:                      *unique,
+		Report:                      *report,
+		ReportFile:                  *reportFile,
+		ReportTopN:                  *reportTopN,
+		CacheFireHOL:                *cache,
+		Allow:                       *allow,
 	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Check failed: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	os.Exit(exitCode(res, *failIfMatches, *failIfRatio))
+}
+
+// exitCode returns exitMatches if any IPs matched, or if any thresholds are
+// given, if the number of matches or the share of IPs matched reached them.
+func exitCode(res *ipcheck.Result, failIfMatches int, failIfRatio float64) int {
+	failed := res.Matches > 0
+	if failIfMatches > 0 || failIfRatio > 0 {
+		failed = failIfMatches > 0 && res.Matches >= failIfMatches ||
+			failIfRatio > 0 && res.MatchRatio() >= failIfRatio
+	}
+
+	if failed {
+		return exitMatches
+	}
+	return exitNoMatches
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+)
+
+func runLookup(args []string) {
+	flags := newFlagSet("lookup", "ipcheck lookup [flags] <IP or CIDR>...", "Looks up IPv4 and IPv6 addresses and CIDRs in all sources.")
+
+	ipRangesFileOrURL := flags.String("ip-ranges", defaultIPRangesURL, "Path or URL to a CSV file with IP ranges to look up IPs in.")
+	fireHOLFile := flags.StringP("firehol-file", "f", "", "Path to a `firehol.ips` file created with `ipcheck download`, to also look up IPs in all FireHOL blocklists.")
+	lists := listFlag(flags)
+	asnFile := flags.String("asn-file", "", "Path to an IP to ASN database in TSV format from https://iptoasn.com (e.g. ip2asn-combined.tsv), to show the ASN of each IP.")
+	filter := filterFlags(flags)
+	rank := flags.Bool("rank", false, "Sort the matches of each IP by the priority and then severity of their sources, the highest first.")
+	asJSON := flags.Bool("json", false, "Output the results as JSON.")
+
+	c := parseFlags(flags, args)
+	sources := configSources(c, flags, ipRangesFileOrURL, *lists)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(exitError)
+	}
+
+	l, err := ipcheck.NewLookup(ipcheck.LookupParams{
+		IPRangesCSVFileOrURL: *ipRangesFileOrURL,
+		FireHOLFile:          *fireHOLFile,
+		Sources:              sources,
+		ASNFile:              *asnFile,
+		Scoring:              c.Scoring,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Lookup failed: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	var results []*ipcheck.LookupResult
+	matched := false
+
+	for _, query := range flags.Args() {
+		res, err := l.Lookup(query)
+		if err == nil {
+			err = res.Filter(filter())
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Lookup failed: %s\n", err)
+			os.Exit(exitError)
+		}
+		if *rank {
+			res.Rank()
+		}
+		results = append(results, res)
+		matched = matched || len(res.Matches) > 0
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	} else {
+		for i, res := range results {
+			if i > 0 {
+				fmt.Println()
+			}
+			printLookupResult(res)
+		}
+	}
+
+	if matched {
+		os.Exit(exitMatches)
+	}
+	os.Exit(exitNoMatches)
+}
+
+func printLookupResult(res *ipcheck.LookupResult) {
+	fmt.Printf("%s\n", res.Query)
+
+	if res.ASN != nil {
+		fmt.Printf("  %-10s AS%d %s (%s)\n", "ASN", res.ASN.Number, res.ASN.Description, res.ASN.Country)
+	}
+
+	if len(res.Matches) == 0 {
+		fmt.Printf("  No matches\n")
+		return
+	}
+
+	fmt.Printf("  %-10s %g, %s\n", "Score", res.Score.Score, res.Score.Verdict)
+
+	for _, m := range res.Matches {
+		fmt.Printf("  %-10s %s (%s severity)\n", m.Category, m.List, m.Severity)
+		fmt.Printf("             %s (%s - %s)\n", m.CIDR, m.First, m.Last)
+		if m.Info != "" {
+			fmt.Printf("             %s\n", m.Info)
+		}
+		if m.Updated != nil {
+			fmt.Printf("             from %s: %s, updated %s ago\n", m.Source, m.Path, formatAge(time.Since(*m.Updated)))
+		} else {
+			fmt.Printf("             from %s: %s\n", m.Source, m.Path)
+		}
+	}
+}
+
+// formatAge formats the age of a list in days, hours or minutes.
+func formatAge(d time.Duration) string {
+	n, unit := int(d/time.Minute), "minute"
+	switch {
+	case d >= 48*time.Hour:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d >= 2*time.Hour:
+		n, unit = int(d/time.Hour), "hour"
+	}
+
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
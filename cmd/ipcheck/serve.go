@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+)
+
+func runServe(args []string) {
+	flags := newFlagSet("serve", "ipcheck serve [flags]", `Serves lookups in all sources over HTTP, as JSON:
+
+  GET  /lookup?q=<IP or CIDR>   Look up one or more IPs or CIDRs (repeat q), see `+"`ipcheck lookup`"+`.
+                                Optionally filter and rank the matches with
+                                category=<category>, min_severity=<severity>
+                                and rank=true.
+  GET  /lists                   List all lists
+  GET  /stats                   Show what's loaded
+  POST /reload                  Reload all sources
+  GET  /healthz                 Health check`)
+
+	addr := flags.String("addr", ":8080", "Address to listen on.")
+	ipRangesFileOrURL := flags.String("ip-ranges", defaultIPRangesURL, "Path or URL to a CSV file with IP ranges to look up IPs in.")
+	fireHOLFile := flags.StringP("firehol-file", "f", "", "Path to a `firehol.ips` file created with `ipcheck download`, to also look up IPs in all FireHOL blocklists.")
+	lists := listFlag(flags)
+	asnFile := flags.String("asn-file", "", "Path to an IP to ASN database in TSV format from https://iptoasn.com (e.g. ip2asn-combined.tsv), to include the ASN of each IP.")
+
+	c := parseFlags(flags, args)
+	sources := configSources(c, flags, ipRangesFileOrURL, *lists)
+
+	s := &server{params: ipcheck.LookupParams{
+		IPRangesCSVFileOrURL: *ipRangesFileOrURL,
+		FireHOLFile:          *fireHOLFile,
+		Sources:              sources,
+		ASNFile:              *asnFile,
+		Scoring:              c.Scoring,
+	}}
+
+	err := s.reload()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load data: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/lookup", s.handleLookup)
+	mux.HandleFunc("/lists", s.handleLists)
+	mux.HandleFunc("/stats", s.handleStats)
+	mux.HandleFunc("/reload", s.handleReload)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	fmt.Printf("Listening on %s\n", *addr)
+
+	err = http.ListenAndServe(*addr, mux)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Server failed: %s\n", err)
+		os.Exit(exitError)
+	}
+}
+
+type server struct {
+	params ipcheck.LookupParams
+	// The current data, swapped on reloads while serving lookups from the
+	// previous data.
+	loaded atomic.Pointer[loadedLookup]
+}
+
+type loadedLookup struct {
+	lookup   *ipcheck.Lookup
+	loadedAt time.Time
+}
+
+func (s *server) reload() error {
+	l, err := ipcheck.NewLookup(s.params)
+	if err != nil {
+		return err
+	}
+	s.loaded.Store(&loadedLookup{lookup: l, loadedAt: time.Now()})
+	return nil
+}
+
+func (s *server) handleLookup(w http.ResponseWriter, r *http.Request) {
+	queries := r.URL.Query()["q"]
+	if len(queries) == 0 {
+		writeError(w, http.StatusBadRequest, "missing query parameter q")
+		return
+	}
+
+	filter := ipcheck.MatchFilter{
+		Categories:  r.URL.Query()["category"],
+		MinSeverity: r.URL.Query().Get("min_severity"),
+	}
+	rank := r.URL.Query().Get("rank") == "true"
+
+	l := s.loaded.Load().lookup
+
+	results := make([]*ipcheck.LookupResult, 0, len(queries))
+	for _, q := range queries {
+		res, err := l.Lookup(q)
+		if err == nil {
+			err = res.Filter(filter)
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if rank {
+			res.Rank()
+		}
+		results = append(results, res)
+	}
+
+	writeJSON(w, results)
+}
+
+func (s *server) handleLists(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.loaded.Load().lookup.Lists())
+}
+
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	loaded := s.loaded.Load()
+	writeJSON(w, struct {
+		ipcheck.LookupStats
+		LoadedAt time.Time `json:"loaded_at"`
+	}{loaded.lookup.Stats(), loaded.loadedAt})
+}
+
+func (s *server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "use POST to reload")
+		return
+	}
+
+	err := s.reload()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	s.handleStats(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
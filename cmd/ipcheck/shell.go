@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/pkg/errors"
+)
+
+const (
+	shellPrompt     = "ipcheck> "
+	shellHistoryMax = 1000
+)
+
+const shellHelp = `Commands:
+  lookup <IP or CIDR>...   Show all ranges and IPs containing an IP, or overlapping a CIDR
+  overlaps <CIDR>          Show all ranges and IPs overlapping any part of a CIDR
+  list [name]              List all lists, or show the metadata and size of a list
+  contains <list> <IP>     Check whether a list contains an IP
+  stats                    Show what's loaded
+  reload                   Reload all sources
+  history                  Show previous commands, rerun them with !<number> or !!
+  help                     Show this help
+  exit                     Leave the shell
+`
+
+func runShell(args []string) {
+	flags := newFlagSet("shell", "ipcheck shell [flags]", "Starts an interactive shell for looking up IPs in all sources.")
+
+	ipRangesFileOrURL := flags.String("ip-ranges", defaultIPRangesURL, "Path or URL to a CSV file with IP ranges to look up IPs in.")
+	fireHOLFile := flags.StringP("firehol-file", "f", "", "Path to a `firehol.ips` file created with `ipcheck download`, to also look up IPs in all FireHOL blocklists.")
+	lists := listFlag(flags)
+	asnFile := flags.String("asn-file", "", "Path to an IP to ASN database in TSV format from https://iptoasn.com (e.g. ip2asn-combined.tsv), to show the ASN of each IP.")
+	historyFile := flags.String("history-file", defaultShellHistoryFile(), "File to keep the command history in (empty to not keep any).")
+
+	c := parseFlags(flags, args)
+	sources := configSources(c, flags, ipRangesFileOrURL, *lists)
+
+	sh := &shell{
+		params: ipcheck.LookupParams{
+			IPRangesCSVFileOrURL: *ipRangesFileOrURL,
+			FireHOLFile:          *fireHOLFile,
+			Sources:              sources,
+			ASNFile:              *asnFile,
+			Scoring:              c.Scoring,
+		},
+		historyFile: *historyFile,
+	}
+
+	err := sh.reload()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load data: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	sh.loadHistory()
+
+	fmt.Printf("Type `help` to see all commands.\n")
+	sh.run()
+}
+
+func defaultShellHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".ipcheck_history")
+}
+
+type shell struct {
+	params   ipcheck.LookupParams
+	lookup   *ipcheck.Lookup
+	loadedAt time.Time
+	loadTook time.Duration
+
+	history     []string
+	historyFile string
+}
+
+func (sh *shell) run() {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	for {
+		fmt.Print(shellPrompt)
+		if !scanner.Scan() {
+			fmt.Println()
+			return
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			cmd, err := sh.fromHistory(line)
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				continue
+			}
+			fmt.Println(cmd)
+			line = cmd
+		}
+
+		sh.addHistory(line)
+
+		if line == "exit" || line == "quit" {
+			return
+		}
+
+		err := sh.exec(strings.Fields(line))
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+		}
+	}
+}
+
+func (sh *shell) exec(args []string) error {
+	switch cmd, args := args[0], args[1:]; cmd {
+	case "help":
+		fmt.Print(shellHelp)
+
+	case "lookup", "overlaps":
+		if len(args) == 0 {
+			return errors.Errorf("usage: %s <IP or CIDR>...", cmd)
+		}
+		for i, query := range args {
+			res, err := sh.lookup.Lookup(query)
+			if err != nil {
+				return err
+			}
+			if i > 0 {
+				fmt.Println()
+			}
+			printLookupResult(res)
+		}
+
+	case "list":
+		if len(args) == 0 {
+			for _, list := range sh.lookup.Lists() {
+				fmt.Printf("  %-10s %-40s %8d CIDRs\n", list.Category, list.Name, list.CIDRs)
+			}
+			return nil
+		}
+
+		list, found := sh.lookup.List(args[0])
+		if !found {
+			return errors.Errorf("unknown list: %s", args[0])
+		}
+		fmt.Printf("  Name       %s\n", list.Name)
+		fmt.Printf("  Category   %s\n", list.Category)
+		if list.Info != "" {
+			fmt.Printf("  Info       %s\n", list.Info)
+		}
+		fmt.Printf("  Size       %d CIDRs and IPs, covering %d IPv4 addresses\n", list.CIDRs, list.IPv4s)
+		fmt.Printf("  Source     %s: %s\n", list.Source, list.Path)
+		fmt.Printf("  Severity   %s (priority %d)\n", list.Severity, list.Priority)
+		if list.Updated != nil {
+			fmt.Printf("  Updated    %s (%s ago)\n", list.Updated.Format(time.RFC3339), formatAge(time.Since(*list.Updated)))
+		}
+
+	case "contains":
+		if len(args) != 2 {
+			return errors.New("usage: contains <list> <IP>")
+		}
+		matches, err := sh.lookup.Contains(args[0], args[1])
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			fmt.Printf("  No, %s is not in %s\n", args[1], args[0])
+			return nil
+		}
+		for _, m := range matches {
+			fmt.Printf("  Yes, %s is in %s (%s - %s)\n", m.CIDR, args[0], m.First, m.Last)
+		}
+
+	case "stats":
+		st := sh.lookup.Stats()
+		fmt.Printf("  %d lists with %d distinct CIDRs and IPs", st.Lists, st.Prefixes)
+		if st.ASNRanges > 0 {
+			fmt.Printf(", %d ASN ranges", st.ASNRanges)
+		}
+		fmt.Printf("\n  Loaded at %s in %s\n", sh.loadedAt.Format(time.RFC3339), sh.loadTook.Round(time.Millisecond))
+
+	case "reload":
+		err := sh.reload()
+		if err != nil {
+			return err
+		}
+		return sh.exec([]string{"stats"})
+
+	case "history":
+		for i, line := range sh.history {
+			fmt.Printf("  %4d  %s\n", i+1, line)
+		}
+
+	default:
+		return errors.Errorf("unknown command %q, type `help` to see all commands", cmd)
+	}
+
+	return nil
+}
+
+// reload (re)loads all sources, keeping the previously loaded data on errors.
+func (sh *shell) reload() error {
+	start := time.Now()
+
+	l, err := ipcheck.NewLookup(sh.params)
+	if err != nil {
+		return err
+	}
+
+	sh.lookup, sh.loadedAt, sh.loadTook = l, time.Now(), time.Since(start)
+
+	return nil
+}
+
+// fromHistory returns the command referred to by "!!" (the previous command)
+// or "!<number>".
+func (sh *shell) fromHistory(ref string) (string, error) {
+	if len(sh.history) == 0 {
+		return "", errors.New("history is empty")
+	}
+
+	if ref == "!!" {
+		return sh.history[len(sh.history)-1], nil
+	}
+
+	n, err := strconv.Atoi(ref[1:])
+	if err != nil || n < 1 || n > len(sh.history) {
+		return "", errors.Errorf("no such command in history: %s", ref)
+	}
+
+	return sh.history[n-1], nil
+}
+
+func (sh *shell) loadHistory() {
+	if sh.historyFile == "" {
+		return
+	}
+
+	data, err := os.ReadFile(sh.historyFile)
+	if err != nil || len(strings.TrimSpace(string(data))) == 0 {
+		return
+	}
+
+	sh.history = strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(sh.history) > shellHistoryMax {
+		sh.history = sh.history[len(sh.history)-shellHistoryMax:]
+	}
+}
+
+func (sh *shell) addHistory(line string) {
+	sh.history = append(sh.history, line)
+
+	if sh.historyFile == "" {
+		return
+	}
+
+	f, err := os.OpenFile(sh.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fmt.Fprintln(f, line)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+)
+
+func runStats(args []string) {
+	flags := newFlagSet("stats", "ipcheck stats [flags]", "Shows all lists loaded from the sources, with their size and age.")
+
+	ipRangesFileOrURL := flags.String("ip-ranges", defaultIPRangesURL, "Path or URL to a CSV file with IP ranges.")
+	fireHOLFile := flags.StringP("firehol-file", "f", "", "Path to a `firehol.ips` file created with `ipcheck download`.")
+	lists := listFlag(flags)
+	asJSON := flags.Bool("json", false, "Output the stats as JSON.")
+
+	c := parseFlags(flags, args)
+	sources := configSources(c, flags, ipRangesFileOrURL, *lists)
+
+	l, err := ipcheck.NewLookup(ipcheck.LookupParams{
+		IPRangesCSVFileOrURL: *ipRangesFileOrURL,
+		FireHOLFile:          *fireHOLFile,
+		Sources:              sources,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not load data: %s\n", err)
+		os.Exit(exitError)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(struct {
+			ipcheck.LookupStats
+			Lists []ipcheck.ListInfo `json:"list_info"`
+		}{l.Stats(), l.Lists()})
+		return
+	}
+
+	var numCIDRs int
+	for _, list := range l.Lists() {
+		var age string
+		if list.Updated != nil {
+			age = formatAge(time.Since(*list.Updated)) + " old"
+		}
+		fmt.Printf("%-20s %-10s %-40s %-8s %8d CIDRs %12d IPv4s  %s\n", list.Source, list.Category, list.Name, list.Severity, list.CIDRs, list.IPv4s, age)
+		numCIDRs += list.CIDRs
+	}
+
+	st := l.Stats()
+	fmt.Printf("\n%d lists with %d CIDRs and IPs (%d distinct)\n", st.Lists, numCIDRs, st.Prefixes)
+}
@@ -0,0 +1,209 @@
+// Package config loads the ipcheck config file. Every setting in the config
+// file corresponds to a command line flag, which is how the settings are
+// applied: flags given on the command line take precedence over environment
+// variables (IPCHECK_<FLAG NAME>, e.g. IPCHECK_FIREHOL_FILE), which take
+// precedence over the config file.
+package config
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultFile is the config file used if none is given and it exists.
+const DefaultFile = "ipcheck.yaml"
+
+// EnvPrefix is the prefix of all environment variables overriding settings.
+const EnvPrefix = "IPCHECK_"
+
+// Config holds all settings of the config file, e.g.:
+//
+//	sources:
+//	  - name: datacenters
+//	    type: ranges-csv
+//	    path: https://raw.githubusercontent.com/jhassine/server-ip-addresses/master/data/datacenters.csv
+//	  - name: firehol
+//	    type: firehol
+//	    path: /data/fire/firehol.ips
+//	    category: threat
+//	    severity: high
+//	    priority: 10
+//	scoring:
+//	  half_life: 72h
+//	  block_at: 80
+//	allow: [office, vpn]
+//	download_dir: /data/fire
+//	check:
+//	  match: narrowest
+//	output:
+//	  report: true
+//	  report_file: ./report.json
+type Config struct {
+	// Sources replace the IP ranges CSV loaded by default, see
+	// ipcheck.Source.
+	Sources []ipcheck.Source `yaml:"sources"`
+	// Scoring configures the risk score of matched IPs, see
+	// ipcheck.Scoring.
+	Scoring ipcheck.Scoring `yaml:"scoring"`
+	// Allow lists patterns of lists (vendors or FireHOL IP sets) whose IPs
+	// are never reported or exported, see path.Match for the syntax.
+	Allow       []string `yaml:"allow"`
+	DownloadDir string   `yaml:"download_dir"`
+	ASNFile     string   `yaml:"asn_file"`
+
+	Check  Check  `yaml:"check"`
+	Output Output `yaml:"output"`
+	Export Export `yaml:"export"`
+	Serve  Serve  `yaml:"serve"`
+}
+
+// Check holds the settings of the check command.
+type Check struct {
+	Match         string   `yaml:"match"`
+	Index         string   `yaml:"index"`
+	Batch         *bool    `yaml:"batch"`
+	Cache         *bool    `yaml:"cache"`
+	MoreInfo      *bool    `yaml:"more_info"`
+	FailIfMatches *int     `yaml:"fail_if_matches"`
+	FailIfRatio   *float64 `yaml:"fail_if_ratio"`
+	// Categories and MinSeverity only report matches from some sources,
+	// see ipcheck.MatchFilter.
+	Categories  []string `yaml:"categories"`
+	MinSeverity string   `yaml:"min_severity"`
+}
+
+// Output holds the output settings of the check command.
+type Output struct {
+	CSVFile    string `yaml:"csv_file"`
+	Unique     *bool  `yaml:"unique"`
+	Report     *bool  `yaml:"report"`
+	ReportFile string `yaml:"report_file"`
+	Top        *int   `yaml:"top"`
+	Verbose    *bool  `yaml:"verbose"`
+}
+
+// Export holds the settings of the export command.
+type Export struct {
+	Format string   `yaml:"format"`
+	Output string   `yaml:"output"`
+	Name   string   `yaml:"name"`
+	Lists  []string `yaml:"lists"`
+}
+
+// Serve holds the settings of the serve command.
+type Serve struct {
+	Addr string `yaml:"addr"`
+}
+
+// Load reads the config file. An empty file name loads DefaultFile if it
+// exists, or returns an empty Config if it doesn't.
+func Load(file string) (*Config, error) {
+	if file == "" {
+		if _, err := os.Stat(DefaultFile); err != nil {
+			return &Config{}, nil
+		}
+		file = DefaultFile
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read config file: %s", file)
+	}
+
+	c := &Config{}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, errors.Wrapf(err, "could not parse config file: %s", file)
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid config file: %s", file)
+	}
+
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	seen := make(map[string]bool)
+
+	for _, s := range c.Sources {
+		if err := s.Validate(); err != nil {
+			return err
+		}
+		if seen[s.Name] {
+			return errors.Errorf("duplicate source name: %s", s.Name)
+		}
+		seen[s.Name] = true
+	}
+
+	return c.Scoring.Validate()
+}
+
+// FlagValues returns the values of all settings in the config file by the
+// name of the command line flag they correspond to.
+func (c *Config) FlagValues() map[string]string {
+	values := make(map[string]string)
+
+	set := func(flag, value string) {
+		if value != "" {
+			values[flag] = value
+		}
+	}
+	setBool := func(flag string, value *bool) {
+		if value != nil {
+			values[flag] = strconv.FormatBool(*value)
+		}
+	}
+	setInt := func(flag string, value *int) {
+		if value != nil {
+			values[flag] = strconv.Itoa(*value)
+		}
+	}
+
+	set("allow", strings.Join(c.Allow, ","))
+	set("dir", c.DownloadDir)
+	set("asn-file", c.ASNFile)
+
+	set("match", c.Check.Match)
+	set("index", c.Check.Index)
+	setBool("batch", c.Check.Batch)
+	setBool("cache", c.Check.Cache)
+	setBool("more-info", c.Check.MoreInfo)
+	setInt("fail-if-matches", c.Check.FailIfMatches)
+	set("category", strings.Join(c.Check.Categories, ","))
+	set("min-severity", c.Check.MinSeverity)
+	if c.Check.FailIfRatio != nil {
+		values["fail-if-ratio"] = strconv.FormatFloat(*c.Check.FailIfRatio, 'f', -1, 64)
+	}
+
+	set("to-csv-file", c.Output.CSVFile)
+	setBool("unique", c.Output.Unique)
+	setBool("report", c.Output.Report)
+	set("report-file", c.Output.ReportFile)
+	setInt("top", c.Output.Top)
+	setBool("verbose", c.Output.Verbose)
+
+	set("format", c.Export.Format)
+	set("output", c.Export.Output)
+	set("name", c.Export.Name)
+	set("lists", strings.Join(c.Export.Lists, ","))
+
+	set("addr", c.Serve.Addr)
+
+	return values
+}
+
+// EnvVar returns the name of the environment variable overriding the given
+// flag, e.g. IPCHECK_FIREHOL_FILE for --firehol-file.
+func EnvVar(flag string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "ipcheck.yaml")
+
+	r.NoError(os.WriteFile(file, []byte(`
+sources:
+  - name: datacenters
+    type: ranges-csv
+    path: ./datacenters.csv
+  - name: firehol
+    type: firehol
+    path: /data/fire/firehol.ips
+    category: threat
+    severity: high
+    priority: 10
+    weight: 80
+scoring:
+  severity_weights: {high: 70}
+  half_life: 72h
+  block_at: 80
+allow: [office, vpn*]
+download_dir: /data/fire
+check:
+  match: narrowest
+  batch: true
+  fail_if_ratio: 0.05
+  categories: [threat]
+output:
+  report: false
+  top: 20
+export:
+  format: nftables
+  lists: [firehol_level1]
+serve:
+  addr: :9090
+`), 0644))
+
+	c, err := Load(file)
+	r.NoError(err)
+	r.Len(c.Sources, 2)
+	weight, blockAt := 80.0, 80.0
+	r.Equal(ipcheck.Source{Name: "firehol", Type: "firehol", Path: "/data/fire/firehol.ips", Category: "threat", Severity: "high", Priority: 10, Weight: &weight}, c.Sources[1])
+	r.Equal(ipcheck.Scoring{SeverityWeights: map[string]float64{"high": 70}, HalfLife: 72 * time.Hour, BlockAt: &blockAt}, c.Scoring)
+
+	r.Equal(map[string]string{
+		"allow":         "office,vpn*",
+		"dir":           "/data/fire",
+		"match":         "narrowest",
+		"batch":         "true",
+		"fail-if-ratio": "0.05",
+		"category":      "threat",
+		"report":        "false",
+		"top":           "20",
+		"format":        "nftables",
+		"lists":         "firehol_level1",
+		"addr":          ":9090",
+	}, c.FlagValues())
+
+	r.Equal("IPCHECK_FIREHOL_FILE", EnvVar("firehol-file"))
+
+	// An empty config file is fine, unknown settings and sources aren't.
+	r.NoError(os.WriteFile(file, nil, 0644))
+	c, err = Load(file)
+	r.NoError(err)
+	r.Empty(c.FlagValues())
+
+	for _, bad := range []string{
+		"unknown: 1\n",
+		"sources:\n  - {name: x, type: x, path: x}\n",
+		"sources:\n  - {name: x, type: firehol}\n",
+		"sources:\n  - {name: x, type: firehol, path: a, severity: x}\n",
+		"sources:\n  - {name: x, type: firehol, path: a}\n  - {name: x, type: list, path: b}\n",
+		"scoring:\n  review_at: 90\n  block_at: 80\n",
+	} {
+		r.NoError(os.WriteFile(file, []byte(bad), 0644))
+		_, err = Load(file)
+		r.Error(err, bad)
+	}
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"))
+	r.Error(err)
+}
@@ -0,0 +1,174 @@
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/anrid/ipcheck/pkg/interval"
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/pkg/errors"
+)
+
+const defaultName = "ipcheck"
+
+// Params controls which range sources are loaded and how they are exported.
+type Params struct {
+	Format               string
+	IPRangesCSVFileOrURL string
+	FireHOLFile          string
+	// Sources are exported in addition to the IP ranges CSV and FireHOL file.
+	Sources    []ipcheck.Source
+	OutputFile string
+	// Lists optionally restricts the export to lists (vendors or FireHOL IP
+	// sets) matching any of these patterns, e.g. "AWS" or "firehol_level*".
+	Lists []string
+	// Allow optionally removes all IPs covered by lists matching any of
+	// these patterns from the export, e.g. to subtract an allowlist.
+	Allow []string
+	// Name of the generated set, table or chain (defaults to "ipcheck").
+	Name          string
+	VerboseOutput bool
+}
+
+type writerFunc func(w io.Writer, ranges []ipcheck.Range, p Params) error
+
+var writers = map[string]writerFunc{
+	"mmdb":     writeMMDB,
+	"ipset":    writeIPSet,
+	"nftables": writeNFTables,
+	"iptables": writeIPTables,
+	"pf":       writePF,
+
+	"nginx-geo":   writeNginxGeo,
+	"nginx-deny":  writeNginxDeny,
+	"haproxy-acl": writeHAProxyACL,
+	"haproxy-map": writeHAProxyMap,
+	"envoy-rbac":  writeEnvoyRBAC,
+	"apache":      writeApache,
+	"caddy":       writeCaddy,
+}
+
+// Formats lists all supported export formats.
+var Formats = []string{
+	"mmdb",
+	"ipset", "nftables", "iptables", "pf",
+	"nginx-geo", "nginx-deny", "haproxy-acl", "haproxy-map", "envoy-rbac", "apache", "caddy",
+}
+
+// Export loads all configured range sources and writes them to the output
+// file (or stdout) in the requested format.
+func Export(p Params) error {
+	write, found := writers[p.Format]
+	if !found {
+		return errors.Errorf("unknown export format %q (supported: %s)", p.Format, strings.Join(Formats, ", "))
+	}
+
+	if p.Name == "" {
+		p.Name = defaultName
+	}
+
+	if p.VerboseOutput {
+		fmt.Fprintf(os.Stderr, "Loading IP ranges from %s ..\n", p.IPRangesCSVFileOrURL)
+	}
+
+	ranges, err := ipcheck.LoadRanges(p.IPRangesCSVFileOrURL, p.FireHOLFile, p.Sources...)
+	if err != nil {
+		return errors.Wrap(err, "could not load IP ranges")
+	}
+
+	var allowed []ipcheck.Range
+	if len(p.Allow) > 0 {
+		allowed, err = filterRanges(ranges, p.Allow)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(p.Lists) > 0 {
+		ranges, err = filterRanges(ranges, p.Lists)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(allowed) > 0 {
+		ranges = subtractRanges(ranges, allowed)
+	}
+
+	if p.VerboseOutput {
+		fmt.Fprintf(os.Stderr, "Loaded %d IP ranges\n", len(ranges))
+	}
+
+	var w io.Writer = os.Stdout
+	if p.OutputFile != "" && p.OutputFile != "-" {
+		f, err := os.Create(p.OutputFile)
+		if err != nil {
+			return errors.Wrapf(err, "could not create output file: %s", p.OutputFile)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err = write(w, ranges, p)
+	if err != nil {
+		return errors.Wrapf(err, "could not export IP ranges as %s", p.Format)
+	}
+
+	if p.VerboseOutput && w != os.Stdout {
+		fmt.Fprintf(os.Stderr, "Wrote %s\n", p.OutputFile)
+	}
+
+	return nil
+}
+
+// filterRanges returns the ranges belonging to lists matching any of the
+// given patterns (see path.Match for the pattern syntax).
+func filterRanges(ranges []ipcheck.Range, patterns []string) ([]ipcheck.Range, error) {
+	var filtered []ipcheck.Range
+
+	for _, r := range ranges {
+		for _, pattern := range patterns {
+			matched, err := path.Match(pattern, r.List)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid list pattern: %s", pattern)
+			}
+			if matched {
+				filtered = append(filtered, r)
+				break
+			}
+		}
+	}
+
+	return filtered, nil
+}
+
+// subtractRanges removes all IPs covered by the allowed ranges from ranges.
+func subtractRanges(ranges, allowed []ipcheck.Range) []ipcheck.Range {
+	toTree := func(ranges []ipcheck.Range) *interval.Tree[[]ipcheck.Range] {
+		tree := interval.NewIntervalTree[[]ipcheck.Range]()
+		for _, r := range ranges {
+			in, _ := interval.NewInterval(iputil.Long2IP(r.Start), iputil.Long2IP(r.End))
+			if existing, err := tree.FindExact(in); err == nil {
+				tree.Upsert(in, append(existing.Payload, r))
+				continue
+			}
+			tree.Upsert(in, []ipcheck.Range{r})
+		}
+		return tree
+	}
+
+	var res []ipcheck.Range
+	toTree(ranges).Difference(toTree(allowed)).Ascend(func(re interval.Result[[]ipcheck.Range]) bool {
+		for _, r := range re.Payload {
+			r.Start, r.End = re.Interval.Start(), re.Interval.Stop()
+			res = append(res, r)
+		}
+		return true
+	})
+
+	return res
+}
@@ -0,0 +1,104 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+)
+
+// Firewall exports write the aggregated CIDRs of all ranges, so that the
+// number of rules stays as small as possible.
+
+func writeIPSet(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	cidrs := aggregate(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "ipset restore", len(cidrs), len(ranges))
+
+	maxElem := 65536
+	for maxElem < len(cidrs) {
+		maxElem *= 2
+	}
+
+	fmt.Fprintf(bw, "create %s hash:net family inet maxelem %d -exist\n", p.Name, maxElem)
+	fmt.Fprintf(bw, "flush %s\n", p.Name)
+	for _, c := range cidrs {
+		fmt.Fprintf(bw, "add %s %s -exist\n", p.Name, c)
+	}
+
+	return bw.Flush()
+}
+
+func writeNFTables(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	cidrs := aggregate(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "nft -f", len(cidrs), len(ranges))
+
+	fmt.Fprintf(bw, "table inet %s {\n", p.Name)
+	fmt.Fprintf(bw, "\tset %s {\n", p.Name)
+	fmt.Fprintf(bw, "\t\ttype ipv4_addr\n")
+	fmt.Fprintf(bw, "\t\tflags interval\n")
+	if len(cidrs) > 0 {
+		fmt.Fprintf(bw, "\t\telements = {\n")
+		for i, c := range cidrs {
+			sep := ","
+			if i == len(cidrs)-1 {
+				sep = ""
+			}
+			fmt.Fprintf(bw, "\t\t\t%s%s\n", c, sep)
+		}
+		fmt.Fprintf(bw, "\t\t}\n")
+	}
+	fmt.Fprintf(bw, "\t}\n")
+	fmt.Fprintf(bw, "}\n")
+
+	return bw.Flush()
+}
+
+func writeIPTables(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	cidrs := aggregate(ranges)
+	chain := strings.ToUpper(p.Name)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "iptables-restore --noflush", len(cidrs), len(ranges))
+	fmt.Fprintf(bw, "# Hook the chain up with e.g.: iptables -I INPUT -j %s\n", chain)
+
+	fmt.Fprintf(bw, "*filter\n")
+	fmt.Fprintf(bw, ":%s - [0:0]\n", chain)
+	fmt.Fprintf(bw, "-F %s\n", chain)
+	for _, c := range cidrs {
+		fmt.Fprintf(bw, "-A %s -s %s -j DROP\n", chain, c)
+	}
+	fmt.Fprintf(bw, "COMMIT\n")
+
+	return bw.Flush()
+}
+
+func writePF(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	cidrs := aggregate(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", fmt.Sprintf("pfctl -t %s -T replace -f", p.Name), len(cidrs), len(ranges))
+	fmt.Fprintf(bw, "# Declare the table in pf.conf with e.g.: table <%s> persist file \"/etc/pf.%s\"\n", p.Name, p.Name)
+
+	for _, c := range cidrs {
+		fmt.Fprintf(bw, "%s\n", c)
+	}
+
+	return bw.Flush()
+}
+
+// writeHeader writes a comment describing the export, using the given
+// comment prefix.
+func writeHeader(w io.Writer, comment, loadWith string, numCIDRs, numRanges int) {
+	fmt.Fprintf(w, "%s Generated by ipcheck at %s\n", comment, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "%s %d CIDRs aggregated from %d IP ranges\n", comment, numCIDRs, numRanges)
+	if loadWith != "" {
+		fmt.Fprintf(w, "%s Load with: %s <file>\n", comment, loadWith)
+	}
+}
@@ -0,0 +1,188 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/stretchr/testify/require"
+)
+
+// testRanges returns ranges of two lists, one with a single IP (exported as a
+// plain IP rather than a /32) and a name with a space, overlapping each other.
+func testRanges() []ipcheck.Range {
+	return []ipcheck.Range{
+		{Start: iputil.IP2Long("10.0.0.0"), End: iputil.IP2Long("10.0.1.255"), List: "AWS"},
+		{Start: iputil.IP2Long("192.168.0.1"), End: iputil.IP2Long("192.168.0.1"), List: "Bad Bots"},
+		{Start: iputil.IP2Long("10.0.2.0"), End: iputil.IP2Long("10.0.2.0"), List: "AWS"},
+		{Start: iputil.IP2Long("10.0.1.0"), End: iputil.IP2Long("10.0.1.255"), List: "Bad Bots"},
+	}
+}
+
+// exportString returns the output of the writer, without the first line of
+// the header as it holds the current time.
+func exportString(r *require.Assertions, write writerFunc, ranges []ipcheck.Range) string {
+	var buf bytes.Buffer
+	r.NoError(write(&buf, ranges, Params{Name: "blocked"}))
+
+	first, rest, _ := strings.Cut(buf.String(), "\n")
+	r.True(strings.HasPrefix(first, "# Generated by ipcheck at "), first)
+	return rest
+}
+
+func TestFirewallFormats(t *testing.T) {
+	r := require.New(t)
+
+	single := []ipcheck.Range{{Start: iputil.IP2Long("10.0.0.0"), End: iputil.IP2Long("10.0.0.255"), List: "a"}}
+
+	for _, test := range []struct {
+		format              string
+		want, single, empty string
+	}{
+		{
+			format: "ipset",
+			want: `# 3 CIDRs aggregated from 4 IP ranges
+# Load with: ipset restore <file>
+create blocked hash:net family inet maxelem 65536 -exist
+flush blocked
+add blocked 10.0.0.0/23 -exist
+add blocked 10.0.2.0 -exist
+add blocked 192.168.0.1 -exist
+`,
+			single: `# 1 CIDRs aggregated from 1 IP ranges
+# Load with: ipset restore <file>
+create blocked hash:net family inet maxelem 65536 -exist
+flush blocked
+add blocked 10.0.0.0/24 -exist
+`,
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Load with: ipset restore <file>
+create blocked hash:net family inet maxelem 65536 -exist
+flush blocked
+`,
+		},
+		{
+			// Elements are separated by commas, without one after the last.
+			format: "nftables",
+			want: `# 3 CIDRs aggregated from 4 IP ranges
+# Load with: nft -f <file>
+table inet blocked {
+	set blocked {
+		type ipv4_addr
+		flags interval
+		elements = {
+			10.0.0.0/23,
+			10.0.2.0,
+			192.168.0.1
+		}
+	}
+}
+`,
+			single: `# 1 CIDRs aggregated from 1 IP ranges
+# Load with: nft -f <file>
+table inet blocked {
+	set blocked {
+		type ipv4_addr
+		flags interval
+		elements = {
+			10.0.0.0/24
+		}
+	}
+}
+`,
+			// An empty elements list is a syntax error.
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Load with: nft -f <file>
+table inet blocked {
+	set blocked {
+		type ipv4_addr
+		flags interval
+	}
+}
+`,
+		},
+		{
+			format: "iptables",
+			want: `# 3 CIDRs aggregated from 4 IP ranges
+# Load with: iptables-restore --noflush <file>
+# Hook the chain up with e.g.: iptables -I INPUT -j BLOCKED
+*filter
+:BLOCKED - [0:0]
+-F BLOCKED
+-A BLOCKED -s 10.0.0.0/23 -j DROP
+-A BLOCKED -s 10.0.2.0 -j DROP
+-A BLOCKED -s 192.168.0.1 -j DROP
+COMMIT
+`,
+			single: `# 1 CIDRs aggregated from 1 IP ranges
+# Load with: iptables-restore --noflush <file>
+# Hook the chain up with e.g.: iptables -I INPUT -j BLOCKED
+*filter
+:BLOCKED - [0:0]
+-F BLOCKED
+-A BLOCKED -s 10.0.0.0/24 -j DROP
+COMMIT
+`,
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Load with: iptables-restore --noflush <file>
+# Hook the chain up with e.g.: iptables -I INPUT -j BLOCKED
+*filter
+:BLOCKED - [0:0]
+-F BLOCKED
+COMMIT
+`,
+		},
+		{
+			format: "pf",
+			want: `# 3 CIDRs aggregated from 4 IP ranges
+# Load with: pfctl -t blocked -T replace -f <file>
+# Declare the table in pf.conf with e.g.: table <blocked> persist file "/etc/pf.blocked"
+10.0.0.0/23
+10.0.2.0
+192.168.0.1
+`,
+			single: `# 1 CIDRs aggregated from 1 IP ranges
+# Load with: pfctl -t blocked -T replace -f <file>
+# Declare the table in pf.conf with e.g.: table <blocked> persist file "/etc/pf.blocked"
+10.0.0.0/24
+`,
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Load with: pfctl -t blocked -T replace -f <file>
+# Declare the table in pf.conf with e.g.: table <blocked> persist file "/etc/pf.blocked"
+`,
+		},
+	} {
+		r.Equal(test.want, exportString(r, writers[test.format], testRanges()), test.format)
+		r.Equal(test.single, exportString(r, writers[test.format], single), test.format)
+		r.Equal(test.empty, exportString(r, writers[test.format], nil), test.format)
+	}
+}
+
+func TestIPSetMaxElem(t *testing.T) {
+	r := require.New(t)
+
+	// Every other IP, so that none of them can be aggregated.
+	ips := func(n int) []ipcheck.Range {
+		ranges := make([]ipcheck.Range, 0, n)
+		for i := 0; i < n; i++ {
+			ip := iputil.IP2Long("10.0.0.0") + uint32(2*i)
+			ranges = append(ranges, ipcheck.Range{Start: ip, End: ip, List: "a"})
+		}
+		return ranges
+	}
+
+	for _, test := range []struct {
+		cidrs, maxElem int
+	}{
+		{65536, 65536},
+		{65537, 131072},
+		{140000, 262144},
+	} {
+		out := exportString(r, writeIPSet, ips(test.cidrs))
+		r.Contains(out, fmt.Sprintf("\ncreate blocked hash:net family inet maxelem %d -exist\n", test.maxElem), test.cidrs)
+		r.Equal(test.cidrs, strings.Count(out, "\nadd blocked "), test.cidrs)
+	}
+}
@@ -0,0 +1,241 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/pkg/errors"
+)
+
+// Writes a MaxMind DB (MMDB) file, see https://maxmind.github.io/MaxMind-DB/
+// for the format spec. The database uses an IPv4 search tree where each
+// network points to a record like:
+//
+//	{"lists": ["AWS", "firehol_level1"]}
+//
+// i.e. the names of all lists (vendors or FireHOL IP sets) covering it.
+
+const (
+	mmdbDatabaseType = "ipcheck"
+	mmdbMetadataMark = "\xab\xcd\xefMaxMind.com"
+)
+
+// MaxMind DB data types.
+const (
+	mmdbTypeString = 2
+	mmdbTypeUint16 = 5
+	mmdbTypeUint32 = 6
+	mmdbTypeMap    = 7
+	mmdbTypeUint64 = 9
+	mmdbTypeArray  = 11
+)
+
+type mmdbNode struct {
+	// Index of the child node for each bit, or -1.
+	children [2]int32
+	// Offset into the data section for each bit, or -1.
+	data [2]int32
+}
+
+// WriteMMDB writes all ranges to w as a MaxMind DB, recording for each
+// network the names of all lists it belongs to.
+func WriteMMDB(w io.Writer, ranges []ipcheck.Range) error {
+	var data mmdbEncoder
+	offsets := make(map[string]int32)
+	nodes := []mmdbNode{newMMDBNode()}
+
+	for _, s := range flatten(ranges) {
+		key := strings.Join(s.lists, "\x00")
+
+		offset, found := offsets[key]
+		if !found {
+			offset = int32(data.Len())
+			offsets[key] = offset
+
+			data.writeMapHeader(1)
+			data.writeString("lists")
+			data.writeArrayHeader(len(s.lists))
+			for _, l := range s.lists {
+				data.writeString(l)
+			}
+		}
+
+		for _, c := range iputil.RangeToCIDRs(s.start, s.end) {
+			nodes = insertMMDBNetwork(nodes, c, offset)
+		}
+	}
+
+	nodeCount := uint64(len(nodes))
+	maxRecord := nodeCount + 16 + uint64(data.Len())
+
+	var recordSize int
+	switch {
+	case maxRecord < 1<<24:
+		recordSize = 24
+	case maxRecord < 1<<28:
+		recordSize = 28
+	case maxRecord < 1<<32:
+		recordSize = 32
+	default:
+		return errors.Errorf("too much data for a MaxMind DB (%d nodes, %d bytes of data)", nodeCount, data.Len())
+	}
+
+	record := func(n mmdbNode, bit int) uint32 {
+		switch {
+		case n.children[bit] >= 0:
+			return uint32(n.children[bit])
+		case n.data[bit] >= 0:
+			return uint32(nodeCount) + 16 + uint32(n.data[bit])
+		default:
+			return uint32(nodeCount)
+		}
+	}
+
+	buf := make([]byte, 0, len(nodes)*recordSize/4)
+
+	for _, n := range nodes {
+		l, r := record(n, 0), record(n, 1)
+
+		switch recordSize {
+		case 24:
+			buf = append(buf, byte(l>>16), byte(l>>8), byte(l), byte(r>>16), byte(r>>8), byte(r))
+		case 28:
+			buf = append(buf, byte(l>>16), byte(l>>8), byte(l), byte(l>>24)<<4|byte(r>>24)&0x0f, byte(r>>16), byte(r>>8), byte(r))
+		case 32:
+			buf = binary.BigEndian.AppendUint32(buf, l)
+			buf = binary.BigEndian.AppendUint32(buf, r)
+		}
+	}
+
+	// Data section separator.
+	buf = append(buf, make([]byte, 16)...)
+
+	var meta mmdbEncoder
+	meta.writeMapHeader(9)
+	meta.writeString("binary_format_major_version")
+	meta.writeUint(mmdbTypeUint16, 2)
+	meta.writeString("binary_format_minor_version")
+	meta.writeUint(mmdbTypeUint16, 0)
+	meta.writeString("build_epoch")
+	meta.writeUint(mmdbTypeUint64, uint64(time.Now().Unix()))
+	meta.writeString("database_type")
+	meta.writeString(mmdbDatabaseType)
+	meta.writeString("description")
+	meta.writeMapHeader(1)
+	meta.writeString("en")
+	meta.writeString("IP ranges and blocklists exported by ipcheck")
+	meta.writeString("ip_version")
+	meta.writeUint(mmdbTypeUint16, 4)
+	meta.writeString("languages")
+	meta.writeArrayHeader(1)
+	meta.writeString("en")
+	meta.writeString("node_count")
+	meta.writeUint(mmdbTypeUint32, nodeCount)
+	meta.writeString("record_size")
+	meta.writeUint(mmdbTypeUint16, uint64(recordSize))
+
+	for _, b := range [][]byte{buf, data.Bytes(), []byte(mmdbMetadataMark), meta.Bytes()} {
+		if _, err := w.Write(b); err != nil {
+			return errors.Wrap(err, "could not write MaxMind DB")
+		}
+	}
+
+	return nil
+}
+
+func newMMDBNode() mmdbNode {
+	return mmdbNode{children: [2]int32{-1, -1}, data: [2]int32{-1, -1}}
+}
+
+// insertMMDBNetwork adds the network c to the search tree, pointing it to the
+// given data section offset.
+func insertMMDBNetwork(nodes []mmdbNode, c iputil.CIDR, offset int32) []mmdbNode {
+	if c.Prefix == 0 {
+		// The whole IPv4 space, point both records of the root to the data.
+		nodes[0].data = [2]int32{offset, offset}
+		return nodes
+	}
+
+	n := 0
+	for depth := 0; depth < c.Prefix-1; depth++ {
+		bit := (c.IP >> (31 - depth)) & 1
+		if nodes[n].children[bit] < 0 {
+			nodes = append(nodes, newMMDBNode())
+			nodes[n].children[bit] = int32(len(nodes) - 1)
+		}
+		n = int(nodes[n].children[bit])
+	}
+
+	bit := (c.IP >> (32 - c.Prefix)) & 1
+	nodes[n].data[bit] = offset
+
+	return nodes
+}
+
+// mmdbEncoder encodes values in the MaxMind DB data section format.
+type mmdbEncoder struct {
+	bytes.Buffer
+}
+
+func (e *mmdbEncoder) writeControl(typ, size int) {
+	var ext []byte
+	sizeBits := size
+
+	switch {
+	case size >= 65821:
+		sizeBits = 31
+		v := size - 65821
+		ext = []byte{byte(v >> 16), byte(v >> 8), byte(v)}
+	case size >= 285:
+		sizeBits = 30
+		v := size - 285
+		ext = []byte{byte(v >> 8), byte(v)}
+	case size >= 29:
+		sizeBits = 29
+		ext = []byte{byte(size - 29)}
+	}
+
+	if typ <= 7 {
+		e.WriteByte(byte(typ<<5 | sizeBits))
+	} else {
+		// Extended type.
+		e.WriteByte(byte(sizeBits))
+		e.WriteByte(byte(typ - 7))
+	}
+	e.Write(ext)
+}
+
+func (e *mmdbEncoder) writeString(s string) {
+	e.writeControl(mmdbTypeString, len(s))
+	e.WriteString(s)
+}
+
+func (e *mmdbEncoder) writeUint(typ int, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+
+	n := 8
+	for n > 0 && b[8-n] == 0 {
+		n--
+	}
+
+	e.writeControl(typ, n)
+	e.Write(b[8-n:])
+}
+
+func (e *mmdbEncoder) writeMapHeader(pairs int) {
+	e.writeControl(mmdbTypeMap, pairs)
+}
+
+func (e *mmdbEncoder) writeArrayHeader(items int) {
+	e.writeControl(mmdbTypeArray, items)
+}
+
+func writeMMDB(w io.Writer, ranges []ipcheck.Range, _ Params) error {
+	return WriteMMDB(w, ranges)
+}
@@ -0,0 +1,151 @@
+package export
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMMDB(t *testing.T) {
+	r := require.New(t)
+
+	ranges := []ipcheck.Range{
+		{Start: iputil.IP2Long("34.64.0.0"), End: iputil.IP2Long("34.127.255.255"), List: "pushing_inertia_blocklist"},
+		{Start: iputil.IP2Long("34.64.160.0"), End: iputil.IP2Long("34.64.191.255"), List: "GCP"},
+		{Start: iputil.IP2Long("3.2.35.192"), End: iputil.IP2Long("3.2.35.255"), List: "AWS"},
+		{Start: iputil.IP2Long("4.4.4.4"), End: iputil.IP2Long("4.4.4.4"), List: "iblocklist_org_joost"},
+	}
+
+	var buf bytes.Buffer
+	r.NoError(WriteMMDB(&buf, ranges))
+
+	db := buf.Bytes()
+	metaStart := bytes.LastIndex(db, []byte(mmdbMetadataMark))
+	r.Greater(metaStart, 0)
+
+	meta, _ := decodeMMDBValue(db[metaStart+len(mmdbMetadataMark):], 0)
+	m := meta.(map[string]interface{})
+	r.Equal(uint64(4), m["ip_version"])
+	r.Equal(uint64(24), m["record_size"])
+
+	nodeCount := m["node_count"].(uint64)
+	dataSection := db[nodeCount*6+16 : metaStart]
+
+	lookup := func(ip string) []interface{} {
+		ipn := iputil.IP2Long(ip)
+		n := uint64(0)
+
+		for depth := 0; depth < 32 && n < nodeCount; depth++ {
+			rec := db[n*6 : n*6+6]
+			if (ipn>>(31-depth))&1 == 0 {
+				n = uint64(rec[0])<<16 | uint64(rec[1])<<8 | uint64(rec[2])
+			} else {
+				n = uint64(rec[3])<<16 | uint64(rec[4])<<8 | uint64(rec[5])
+			}
+		}
+		if n <= nodeCount {
+			return nil
+		}
+
+		v, _ := decodeMMDBValue(dataSection, int(n-nodeCount-16))
+		return v.(map[string]interface{})["lists"].([]interface{})
+	}
+
+	r.Equal([]interface{}{"GCP", "pushing_inertia_blocklist"}, lookup("34.64.161.255"))
+	r.Equal([]interface{}{"pushing_inertia_blocklist"}, lookup("34.64.0.0"))
+	r.Equal([]interface{}{"pushing_inertia_blocklist"}, lookup("34.127.255.255"))
+	r.Equal([]interface{}{"AWS"}, lookup("3.2.35.193"))
+	r.Equal([]interface{}{"iblocklist_org_joost"}, lookup("4.4.4.4"))
+	r.Nil(lookup("4.4.4.5"))
+	r.Nil(lookup("34.128.0.0"))
+	r.Nil(lookup("8.8.8.8"))
+
+	// The database must also be readable by MaxMind's own reader.
+	reader, err := maxminddb.FromBytes(db)
+	r.NoError(err)
+	r.NoError(reader.Verify())
+	r.Equal("ipcheck", reader.Metadata.DatabaseType)
+	r.Equal(uint(4), reader.Metadata.IPVersion)
+
+	var record struct {
+		Lists []string `maxminddb:"lists"`
+	}
+
+	network, found, err := reader.LookupNetwork(net.ParseIP("34.64.161.255"), &record)
+	r.NoError(err)
+	r.True(found)
+	r.Equal("34.64.160.0/19", network.String())
+	r.Equal([]string{"GCP", "pushing_inertia_blocklist"}, record.Lists)
+
+	record.Lists = nil
+	network, found, err = reader.LookupNetwork(net.ParseIP("4.4.4.4"), &record)
+	r.NoError(err)
+	r.True(found)
+	r.Equal("4.4.4.4/32", network.String())
+	r.Equal([]string{"iblocklist_org_joost"}, record.Lists)
+
+	_, found, err = reader.LookupNetwork(net.ParseIP("8.8.8.8"), &record)
+	r.NoError(err)
+	r.False(found)
+}
+
+// decodeMMDBValue decodes the subset of MaxMind DB types written by
+// WriteMMDB, returning the value and the offset following it.
+func decodeMMDBValue(b []byte, offset int) (interface{}, int) {
+	ctrl := b[offset]
+	offset++
+
+	typ := int(ctrl >> 5)
+	if typ == 0 {
+		typ = int(b[offset]) + 7
+		offset++
+	}
+
+	size := int(ctrl & 0x1f)
+	switch size {
+	case 29:
+		size = 29 + int(b[offset])
+		offset++
+	case 30:
+		size = 285 + (int(b[offset])<<8 | int(b[offset+1]))
+		offset += 2
+	case 31:
+		size = 65821 + (int(b[offset])<<16 | int(b[offset+1])<<8 | int(b[offset+2]))
+		offset += 3
+	}
+
+	switch typ {
+	case mmdbTypeString:
+		return string(b[offset : offset+size]), offset + size
+	case mmdbTypeUint16, mmdbTypeUint32, mmdbTypeUint64:
+		var v uint64
+		for _, c := range b[offset : offset+size] {
+			v = v<<8 | uint64(c)
+		}
+		return v, offset + size
+	case mmdbTypeMap:
+		m := make(map[string]interface{})
+		for i := 0; i < size; i++ {
+			var k, v interface{}
+			k, offset = decodeMMDBValue(b, offset)
+			v, offset = decodeMMDBValue(b, offset)
+			m[k.(string)] = v
+		}
+		return m, offset
+	case mmdbTypeArray:
+		a := make([]interface{}, 0, size)
+		for i := 0; i < size; i++ {
+			var v interface{}
+			v, offset = decodeMMDBValue(b, offset)
+			a = append(a, v)
+		}
+		return a, offset
+	}
+
+	panic("unsupported type")
+}
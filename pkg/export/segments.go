@@ -0,0 +1,94 @@
+package export
+
+import (
+	"sort"
+
+	"github.com/anrid/ipcheck/pkg/interval"
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/anrid/ipcheck/pkg/iputil"
+)
+
+// segment is a range of IPs covered by exactly the same set of lists.
+type segment struct {
+	start uint32
+	end   uint32
+	lists []string
+}
+
+// flatten turns a set of (possibly overlapping) ranges into sorted,
+// non-overlapping segments, each carrying the names of all lists covering it.
+func flatten(ranges []ipcheck.Range) []segment {
+	entries := make([]interval.Entry[string], 0, len(ranges))
+	for _, r := range ranges {
+		in, _ := interval.NewInterval(iputil.Long2IP(r.Start), iputil.Long2IP(r.End))
+		entries = append(entries, interval.Entry[string]{Interval: in, Payload: r.List})
+	}
+
+	flat := interval.Flatten(entries)
+
+	segments := make([]segment, 0, len(flat))
+	for _, s := range flat {
+		lists := append([]string(nil), s.Payloads...)
+		sort.Strings(lists)
+		segments = append(segments, segment{start: s.Interval.Start(), end: s.Interval.Stop(), lists: lists})
+	}
+
+	return segments
+}
+
+// aggregate merges all overlapping and adjacent ranges, regardless of the list
+// they belong to, and returns the smallest set of CIDRs covering them.
+func aggregate(ranges []ipcheck.Range) []iputil.CIDR {
+	ipRanges := make([]iputil.IPRange, 0, len(ranges))
+	for _, r := range ranges {
+		ipRanges = append(ipRanges, iputil.IPRange{Start: r.Start, End: r.End})
+	}
+	return iputil.RangesToCIDRs(ipRanges)
+}
+
+// listCIDRs holds the aggregated CIDRs of a single list.
+type listCIDRs struct {
+	list  string
+	cidrs []iputil.CIDR
+}
+
+// aggregateByList aggregates the ranges of each list separately, returning
+// the lists in the order they first appear in. A CIDR found in several lists
+// is only returned for the first of them, as e.g. nginx rejects a geo block
+// with duplicate networks. Lists left without CIDRs are dropped.
+func aggregateByList(ranges []ipcheck.Range) []listCIDRs {
+	var lists []string
+	byList := make(map[string][]ipcheck.Range)
+
+	for _, r := range ranges {
+		if _, found := byList[r.List]; !found {
+			lists = append(lists, r.List)
+		}
+		byList[r.List] = append(byList[r.List], r)
+	}
+
+	seen := make(map[iputil.CIDR]bool)
+
+	res := make([]listCIDRs, 0, len(lists))
+	for _, l := range lists {
+		var cidrs []iputil.CIDR
+		for _, c := range aggregate(byList[l]) {
+			if !seen[c] {
+				seen[c] = true
+				cidrs = append(cidrs, c)
+			}
+		}
+		if len(cidrs) > 0 {
+			res = append(res, listCIDRs{list: l, cidrs: cidrs})
+		}
+	}
+
+	return res
+}
+
+func countCIDRs(lists []listCIDRs) (n int) {
+	for _, l := range lists {
+		n += len(l.cidrs)
+	}
+	return n
+}
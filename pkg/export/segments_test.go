@@ -0,0 +1,53 @@
+package export
+
+import (
+	"testing"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregate(t *testing.T) {
+	r := require.New(t)
+
+	ranges := []ipcheck.Range{
+		{Start: iputil.IP2Long("10.0.1.0"), End: iputil.IP2Long("10.0.1.255"), List: "a"},
+		{Start: iputil.IP2Long("10.0.0.0"), End: iputil.IP2Long("10.0.0.255"), List: "b"},
+		{Start: iputil.IP2Long("10.0.0.128"), End: iputil.IP2Long("10.0.0.130"), List: "c"},
+		{Start: iputil.IP2Long("10.0.2.0"), End: iputil.IP2Long("10.0.2.0"), List: "c"},
+		{Start: iputil.IP2Long("192.168.0.1"), End: iputil.IP2Long("192.168.0.6"), List: "d"},
+	}
+
+	var got []string
+	for _, c := range aggregate(ranges) {
+		got = append(got, c.String())
+	}
+
+	r.Equal([]string{
+		"10.0.0.0/23",
+		"10.0.2.0",
+		"192.168.0.1",
+		"192.168.0.2/31",
+		"192.168.0.4/31",
+		"192.168.0.6",
+	}, got)
+}
+
+func TestFlatten(t *testing.T) {
+	r := require.New(t)
+
+	ranges := []ipcheck.Range{
+		{Start: 10, End: 20, List: "a"},
+		{Start: 15, End: 30, List: "b"},
+		{Start: 21, End: 25, List: "a"},
+		{Start: 40, End: 40, List: "c"},
+	}
+
+	r.Equal([]segment{
+		{start: 10, end: 14, lists: []string{"a"}},
+		{start: 15, end: 25, lists: []string{"a", "b"}},
+		{start: 26, end: 30, lists: []string{"b"}},
+		{start: 40, end: 40, lists: []string{"c"}},
+	}, flatten(ranges))
+}
@@ -0,0 +1,164 @@
+package export
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/anrid/ipcheck/pkg/iputil"
+)
+
+// Web server and proxy exports group the aggregated CIDRs by the list they
+// came from, with the name of each list in a comment above its CIDRs, see
+// aggregateByList.
+
+func writeNginxGeo(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	lists := aggregateByList(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "", countCIDRs(lists), len(ranges))
+	fmt.Fprintf(bw, "# Include inside the http block, then e.g.: if ($%s) { return 403; }\n", p.Name)
+
+	fmt.Fprintf(bw, "geo $%s {\n", p.Name)
+	fmt.Fprintf(bw, "    default \"\";\n")
+	for _, l := range lists {
+		fmt.Fprintf(bw, "\n    # %s\n", l.list)
+		for _, c := range l.cidrs {
+			fmt.Fprintf(bw, "    %s %q;\n", c, l.list)
+		}
+	}
+	fmt.Fprintf(bw, "}\n")
+
+	return bw.Flush()
+}
+
+func writeNginxDeny(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	lists := aggregateByList(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "", countCIDRs(lists), len(ranges))
+	fmt.Fprintf(bw, "# Include inside a http, server or location block\n")
+
+	for _, l := range lists {
+		fmt.Fprintf(bw, "\n# %s\n", l.list)
+		for _, c := range l.cidrs {
+			fmt.Fprintf(bw, "deny %s;\n", c)
+		}
+	}
+
+	return bw.Flush()
+}
+
+func writeHAProxyACL(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	lists := aggregateByList(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "", countCIDRs(lists), len(ranges))
+	fmt.Fprintf(bw, "# Use with e.g.: http-request deny if { src -f /etc/haproxy/%s.acl }\n", p.Name)
+
+	for _, l := range lists {
+		fmt.Fprintf(bw, "\n# %s\n", l.list)
+		for _, c := range l.cidrs {
+			fmt.Fprintf(bw, "%s\n", c)
+		}
+	}
+
+	return bw.Flush()
+}
+
+func writeHAProxyMap(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	lists := aggregateByList(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "", countCIDRs(lists), len(ranges))
+	fmt.Fprintf(bw, "# Use with e.g.: http-request set-header X-IPCheck %%[src,map_ip(/etc/haproxy/%s.map)]\n", p.Name)
+
+	for _, l := range lists {
+		fmt.Fprintf(bw, "\n# %s\n", l.list)
+		for _, c := range l.cidrs {
+			fmt.Fprintf(bw, "%s %s\n", c, strings.ReplaceAll(l.list, " ", "_"))
+		}
+	}
+
+	return bw.Flush()
+}
+
+func writeEnvoyRBAC(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	lists := aggregateByList(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "", countCIDRs(lists), len(ranges))
+
+	fmt.Fprintf(bw, "name: envoy.filters.http.rbac\n")
+	fmt.Fprintf(bw, "typed_config:\n")
+	fmt.Fprintf(bw, "  \"@type\": type.googleapis.com/envoy.extensions.filters.http.rbac.v3.RBAC\n")
+	fmt.Fprintf(bw, "  rules:\n")
+	fmt.Fprintf(bw, "    action: DENY\n")
+	if len(lists) == 0 {
+		fmt.Fprintf(bw, "    policies: {}\n")
+		return bw.Flush()
+	}
+	fmt.Fprintf(bw, "    policies:\n")
+	for _, l := range lists {
+		fmt.Fprintf(bw, "      # %s\n", l.list)
+		fmt.Fprintf(bw, "      %q:\n", p.Name+"-"+l.list)
+		fmt.Fprintf(bw, "        permissions:\n")
+		fmt.Fprintf(bw, "          - any: true\n")
+		fmt.Fprintf(bw, "        principals:\n")
+		for _, c := range l.cidrs {
+			fmt.Fprintf(bw, "          - remote_ip: { address_prefix: %q, prefix_len: %d }\n", iputil.Long2IP(c.IP), c.Prefix)
+		}
+	}
+
+	return bw.Flush()
+}
+
+func writeApache(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	lists := aggregateByList(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "", countCIDRs(lists), len(ranges))
+	fmt.Fprintf(bw, "# Include inside a <Directory> or <Location> block\n")
+
+	fmt.Fprintf(bw, "<RequireAll>\n")
+	fmt.Fprintf(bw, "    Require all granted\n")
+	for _, l := range lists {
+		fmt.Fprintf(bw, "\n    # %s\n", l.list)
+		for _, c := range l.cidrs {
+			fmt.Fprintf(bw, "    Require not ip %s\n", c)
+		}
+	}
+	fmt.Fprintf(bw, "</RequireAll>\n")
+
+	return bw.Flush()
+}
+
+func writeCaddy(w io.Writer, ranges []ipcheck.Range, p Params) error {
+	lists := aggregateByList(ranges)
+
+	bw := bufio.NewWriter(w)
+	writeHeader(bw, "#", "", countCIDRs(lists), len(ranges))
+	fmt.Fprintf(bw, "# Import inside a site block with: import %s\n", p.Name)
+
+	fmt.Fprintf(bw, "(%s) {\n", p.Name)
+	if len(lists) == 0 {
+		// An empty matcher matches all requests.
+		fmt.Fprintf(bw, "\t# No IP ranges to block\n")
+	}
+	for i, l := range lists {
+		matcher := fmt.Sprintf("@%s_%d", p.Name, i+1)
+
+		fmt.Fprintf(bw, "\t# %s\n", l.list)
+		fmt.Fprintf(bw, "\t%s remote_ip", matcher)
+		for _, c := range l.cidrs {
+			fmt.Fprintf(bw, " %s", c)
+		}
+		fmt.Fprintf(bw, "\n")
+		fmt.Fprintf(bw, "\tabort %s\n", matcher)
+	}
+	fmt.Fprintf(bw, "}\n")
+
+	return bw.Flush()
+}
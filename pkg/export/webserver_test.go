@@ -0,0 +1,193 @@
+package export
+
+import (
+	"testing"
+
+	"github.com/anrid/ipcheck/pkg/ipcheck"
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebServerFormats(t *testing.T) {
+	r := require.New(t)
+
+	for _, test := range []struct {
+		format      string
+		want, empty string
+	}{
+		{
+			format: "nginx-geo",
+			want: `# 4 CIDRs aggregated from 4 IP ranges
+# Include inside the http block, then e.g.: if ($blocked) { return 403; }
+geo $blocked {
+    default "";
+
+    # AWS
+    10.0.0.0/23 "AWS";
+    10.0.2.0 "AWS";
+
+    # Bad Bots
+    10.0.1.0/24 "Bad Bots";
+    192.168.0.1 "Bad Bots";
+}
+`,
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Include inside the http block, then e.g.: if ($blocked) { return 403; }
+geo $blocked {
+    default "";
+}
+`,
+		},
+		{
+			format: "nginx-deny",
+			want: `# 4 CIDRs aggregated from 4 IP ranges
+# Include inside a http, server or location block
+
+# AWS
+deny 10.0.0.0/23;
+deny 10.0.2.0;
+
+# Bad Bots
+deny 10.0.1.0/24;
+deny 192.168.0.1;
+`,
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Include inside a http, server or location block
+`,
+		},
+		{
+			format: "haproxy-acl",
+			want: `# 4 CIDRs aggregated from 4 IP ranges
+# Use with e.g.: http-request deny if { src -f /etc/haproxy/blocked.acl }
+
+# AWS
+10.0.0.0/23
+10.0.2.0
+
+# Bad Bots
+10.0.1.0/24
+192.168.0.1
+`,
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Use with e.g.: http-request deny if { src -f /etc/haproxy/blocked.acl }
+`,
+		},
+		{
+			format: "haproxy-map",
+			want: `# 4 CIDRs aggregated from 4 IP ranges
+# Use with e.g.: http-request set-header X-IPCheck %[src,map_ip(/etc/haproxy/blocked.map)]
+
+# AWS
+10.0.0.0/23 AWS
+10.0.2.0 AWS
+
+# Bad Bots
+10.0.1.0/24 Bad_Bots
+192.168.0.1 Bad_Bots
+`,
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Use with e.g.: http-request set-header X-IPCheck %[src,map_ip(/etc/haproxy/blocked.map)]
+`,
+		},
+		{
+			format: "envoy-rbac",
+			want: `# 4 CIDRs aggregated from 4 IP ranges
+name: envoy.filters.http.rbac
+typed_config:
+  "@type": type.googleapis.com/envoy.extensions.filters.http.rbac.v3.RBAC
+  rules:
+    action: DENY
+    policies:
+      # AWS
+      "blocked-AWS":
+        permissions:
+          - any: true
+        principals:
+          - remote_ip: { address_prefix: "10.0.0.0", prefix_len: 23 }
+          - remote_ip: { address_prefix: "10.0.2.0", prefix_len: 32 }
+      # Bad Bots
+      "blocked-Bad Bots":
+        permissions:
+          - any: true
+        principals:
+          - remote_ip: { address_prefix: "10.0.1.0", prefix_len: 24 }
+          - remote_ip: { address_prefix: "192.168.0.1", prefix_len: 32 }
+`,
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+name: envoy.filters.http.rbac
+typed_config:
+  "@type": type.googleapis.com/envoy.extensions.filters.http.rbac.v3.RBAC
+  rules:
+    action: DENY
+    policies: {}
+`,
+		},
+		{
+			format: "apache",
+			want: `# 4 CIDRs aggregated from 4 IP ranges
+# Include inside a <Directory> or <Location> block
+<RequireAll>
+    Require all granted
+
+    # AWS
+    Require not ip 10.0.0.0/23
+    Require not ip 10.0.2.0
+
+    # Bad Bots
+    Require not ip 10.0.1.0/24
+    Require not ip 192.168.0.1
+</RequireAll>
+`,
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Include inside a <Directory> or <Location> block
+<RequireAll>
+    Require all granted
+</RequireAll>
+`,
+		},
+		{
+			format: "caddy",
+			want: `# 4 CIDRs aggregated from 4 IP ranges
+# Import inside a site block with: import blocked
+(blocked) {
+	# AWS
+	@blocked_1 remote_ip 10.0.0.0/23 10.0.2.0
+	abort @blocked_1
+	# Bad Bots
+	@blocked_2 remote_ip 10.0.1.0/24 192.168.0.1
+	abort @blocked_2
+}
+`,
+			// No matcher at all, an empty one would abort every request.
+			empty: `# 0 CIDRs aggregated from 0 IP ranges
+# Import inside a site block with: import blocked
+(blocked) {
+	# No IP ranges to block
+}
+`,
+		},
+	} {
+		r.Equal(test.want, exportString(r, writers[test.format], testRanges()), test.format)
+		r.Equal(test.empty, exportString(r, writers[test.format], nil), test.format)
+	}
+
+	// A network found in several lists is only written for the first one.
+	ranges := append(testRanges(),
+		ipcheck.Range{Start: iputil.IP2Long("10.0.2.0"), End: iputil.IP2Long("10.0.2.0"), List: "Bad Bots"},
+		ipcheck.Range{Start: iputil.IP2Long("10.0.1.0"), End: iputil.IP2Long("10.0.1.255"), List: "Scanners"},
+	)
+	r.Equal(`# 4 CIDRs aggregated from 6 IP ranges
+# Include inside the http block, then e.g.: if ($blocked) { return 403; }
+geo $blocked {
+    default "";
+
+    # AWS
+    10.0.0.0/23 "AWS";
+    10.0.2.0 "AWS";
+
+    # Bad Bots
+    10.0.1.0/24 "Bad Bots";
+    192.168.0.1 "Bad Bots";
+}
+`, exportString(r, writeNginxGeo, ranges))
+}
@@ -7,6 +7,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/anrid/ipcheck/pkg/iputil"
 	"github.com/pkg/errors"
@@ -19,7 +20,7 @@ const (
 func Download(outDir string, forceDownload bool) error {
 	err := createDirIfNotExists(outDir)
 	if err != nil {
-		errors.Wrapf(err, "failed to create FireHOL import dir: %s", outDir)
+		return errors.Wrapf(err, "failed to create FireHOL import dir: %s", outDir)
 	}
 
 	unpackedDir := filepath.Join(outDir, "blocklist-ipsets-master")
@@ -43,7 +44,7 @@ func Download(outDir string, forceDownload bool) error {
 			if err != nil {
 				return errors.Wrapf(err, "could not execute `wget` command on URL: %s", fireHOLBlocklistRepoURL)
 			}
-			unzip(outDir, zipFile)
+			err = unzip(outDir, zipFile)
 			if err != nil {
 				return errors.Wrapf(err, "could not execute `unzip` command on zip file: %s", zipFile)
 			}
@@ -94,16 +95,31 @@ SKIP:
 
 		ips, err := loadIPSet(f)
 		if err != nil {
-			fmt.Printf("Skipping invaild IP set: %s\n", f)
+			fmt.Printf("Skipping invalid IP set: %s\n", f)
 			continue
 		}
 
+		cidrs, err := aggregateCIDRs(ips.CIDRs)
+		if err != nil {
+			fmt.Printf("Skipping invalid IP set: %s (%s)\n", f, err)
+			continue
+		}
+		ips.CIDRs = cidrs
+
 		importedSets++
 
-		fmt.Printf("Loaded IP set: %s (%d CIDRs, %d IPs)\n", ips.Name, len(ips.CIDRs), len(ips.IPs))
+		if ips.Skipped > 0 {
+			fmt.Printf("Loaded IP set: %s (%d CIDRs, %d IPs, skipped %d invalid lines)\n", ips.Name, len(ips.CIDRs), len(ips.IPs), ips.Skipped)
+		} else {
+			fmt.Printf("Loaded IP set: %s (%d CIDRs, %d IPs)\n", ips.Name, len(ips.CIDRs), len(ips.IPs))
+		}
 
 		if len(ips.CIDRs) > 0 || len(ips.IPs) > 0 {
-			of.WriteString(fmt.Sprintf("# %s | %s | %s (%d CIDRs, %d IPs)\n", ips.Name, ips.Maintainer, ips.MaintainerURL, len(ips.CIDRs), len(ips.IPs)))
+			var updated string
+			if !ips.Updated.IsZero() {
+				updated = " | updated " + ips.Updated.UTC().Format(time.RFC3339)
+			}
+			of.WriteString(fmt.Sprintf("# %s | %s | %s%s (%d CIDRs, %d IPs)\n", ips.Name, ips.Maintainer, ips.MaintainerURL, updated, len(ips.CIDRs), len(ips.IPs)))
 
 			for _, cidr := range ips.CIDRs {
 				of.WriteString(cidr)
@@ -139,8 +155,14 @@ type IPSet struct {
 	Name          string
 	Maintainer    string
 	MaintainerURL string
-	CIDRs         []string
-	IPs           []string
+	// Updated is the time the source of the IP set was last updated, if
+	// known.
+	Updated time.Time
+	CIDRs   []string
+	IPs     []string
+	// Skipped is the number of lines skipped because they don't hold a valid
+	// IPv4 address, CIDR or range.
+	Skipped int
 }
 
 func loadIPSet(file string) (*IPSet, error) {
@@ -173,23 +195,81 @@ func loadIPSet(file string) (*IPSet, error) {
 					} else if strings.HasPrefix(l, "# Maintainer") {
 						parts := strings.Split(l, " : ")
 						ips.Maintainer = parts[1]
+					} else if strings.HasPrefix(l, "# Source File Date") {
+						parts := strings.SplitN(l, ":", 2)
+						ips.Updated = parseIPSetDate(parts[1])
 					}
 				}
-			} else if len(l) >= 8 {
-				// Found IP or CIDR.
-				if strings.Contains(l, "/") {
-					// Is CIDR.
-					ips.CIDRs = append(ips.CIDRs, l)
-				} else {
-					ips.IPs = append(ips.IPs, l)
-				}
+				continue
 			}
 		}
+
+		// Found IP, CIDR or IP range.
+		entry, _, _ := iputil.ParseListLine(l)
+		if entry == "" {
+			continue
+		}
+		// Skip invalid lines rather than the whole IP set.
+		var cidrs, singleIPs []string
+		err := iputil.ExpandListEntry(entry, func(cidr string) error {
+			if _, err := iputil.ParseCIDR(cidr); err != nil {
+				return err
+			}
+			if strings.Contains(cidr, "/") {
+				// Is CIDR.
+				cidrs = append(cidrs, cidr)
+			} else {
+				singleIPs = append(singleIPs, cidr)
+			}
+			return nil
+		})
+		if err != nil {
+			ips.Skipped++
+			continue
+		}
+		ips.CIDRs = append(ips.CIDRs, cidrs...)
+		ips.IPs = append(ips.IPs, singleIPs...)
 	}
 
 	return ips, nil
 }
 
+// parseIPSetDate parses a date in an IP set header, e.g.
+// "Sat Apr  1 09:09:53 UTC 2023". Returns the zero time if it can't be parsed.
+func parseIPSetDate(date string) time.Time {
+	t, err := time.Parse("Mon Jan 2 15:04:05 MST 2006", strings.Join(strings.Fields(date), " "))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// aggregateCIDRs collapses overlapping and adjacent CIDRs of an IP set, which
+// keeps the number of ranges to load (and export) down.
+func aggregateCIDRs(cidrs []string) ([]string, error) {
+	parsed := make([]iputil.CIDR, 0, len(cidrs))
+	for _, c := range cidrs {
+		p, err := iputil.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, p)
+	}
+
+	aggregated := make([]string, 0, len(parsed))
+	for _, c := range iputil.AggregateCIDRs(parsed) {
+		if c.Prefix == 32 {
+			// Keep the CIDR notation, plain IPs are treated as single IPs
+			// when loading the merged file.
+			aggregated = append(aggregated, c.String()+"/32")
+			continue
+		}
+		aggregated = append(aggregated, c.String())
+	}
+
+	return aggregated, nil
+}
+
 func findAllIPAndNetsets(dir string) (files []string, err error) {
 	var totalSize int64
 
@@ -0,0 +1,115 @@
+package interval
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// LookupBatch looks up all given IPs at once and returns all intervals
+// containing each IP, in the same order as the IPs. Instead of searching the
+// tree once per IP, the IPs are sorted and matched against the intervals in a
+// single merge-style sweep over the tree, which only takes the read lock
+// once. The results for each IP are sorted by interval.
+func (t *Tree[T]) LookupBatch(ips []uint32) [][]Result[T] {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	res := make([][]Result[T], len(ips))
+	order := sortedOrder(ips)
+
+	// Intervals starting at or before the current IP, ordered by their
+	// upper bound so we can drop the ones ending before it.
+	active := &activeNodes[T]{}
+	next := t.min(t.root)
+	var seq int
+
+	for _, i := range order {
+		ip := ips[i]
+
+		for next != t.sentinel && next.key.low <= ip {
+			heap.Push(active, activeNode[T]{node: next, seq: seq})
+			seq++
+			next = t.successor(next)
+		}
+
+		for active.Len() > 0 && (*active)[0].node.key.high < ip {
+			heap.Pop(active)
+		}
+
+		if active.Len() == 0 {
+			continue
+		}
+
+		matches := make([]activeNode[T], active.Len())
+		copy(matches, *active)
+		sort.Slice(matches, func(i, j int) bool { return matches[i].seq < matches[j].seq })
+
+		res[i] = make([]Result[T], 0, len(matches))
+		for _, m := range matches {
+			res[i] = append(res[i], Result[T]{Interval: m.node.key, Payload: m.node.payload})
+		}
+	}
+
+	return res
+}
+
+// LookupBatch looks up all given IPs at once and returns all intervals
+// containing each IP, in the same order as the IPs. The results for each IP
+// are sorted by interval.
+func (idx *FlatIndex[T]) LookupBatch(ips []uint32) [][]Result[T] {
+	res := make([][]Result[T], len(ips))
+	var s int
+
+	for _, i := range sortedOrder(ips) {
+		ip := ips[i]
+
+		for s < len(idx.starts) && idx.ends[s] < ip {
+			s++
+		}
+		if s == len(idx.starts) {
+			break
+		}
+		if idx.starts[s] > ip {
+			continue
+		}
+
+		set := idx.sets[idx.setIDs[s]]
+		res[i] = make([]Result[T], 0, len(set))
+		for _, id := range set {
+			res[i] = append(res[i], idx.results[id])
+		}
+	}
+
+	return res
+}
+
+// sortedOrder returns the positions of the IPs, sorted by IP.
+func sortedOrder(ips []uint32) []int {
+	order := make([]int, len(ips))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return ips[order[i]] < ips[order[j]] })
+	return order
+}
+
+type activeNode[T any] struct {
+	node *node[T]
+	// Position of the node in the tree, used to sort matches.
+	seq int
+}
+
+// activeNodes is a min-heap of nodes ordered by their upper bound.
+type activeNodes[T any] []activeNode[T]
+
+func (h activeNodes[T]) Len() int            { return len(h) }
+func (h activeNodes[T]) Less(i, j int) bool  { return h[i].node.key.high < h[j].node.key.high }
+func (h activeNodes[T]) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *activeNodes[T]) Push(x interface{}) { *h = append(*h, x.(activeNode[T])) }
+
+func (h *activeNodes[T]) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
@@ -0,0 +1,106 @@
+package interval
+
+import (
+	"math/bits"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Entry is an interval and its payload, used to bulk load a tree.
+type Entry[T any] struct {
+	Interval Interval
+	Payload  T
+}
+
+// Build returns a new balanced tree containing all entries. The entries are
+// sorted once and the tree is constructed in linear time, which is a lot
+// faster than calling Upsert for each entry. As with Upsert, the last entry
+// wins if the same interval occurs more than once.
+func Build[T any](entries []Entry[T]) *Tree[T] {
+	// Sort positions rather than entries, it's a lot cheaper to swap them
+	// and they keep the sort stable.
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		a, b := entries[order[i]].Interval, entries[order[j]].Interval
+		if a.low != b.low || a.high != b.high {
+			return a.less(b)
+		}
+		return order[i] < order[j]
+	})
+
+	sorted := make([]Entry[T], len(entries))
+	for i, pos := range order {
+		sorted[i] = entries[pos]
+	}
+
+	return buildFromSorted(sorted)
+}
+
+// BuildFromSorted is like Build, but expects the entries to already be sorted
+// by interval (lower bound first, then upper bound). Returns an error if they
+// aren't.
+func BuildFromSorted[T any](entries []Entry[T]) (*Tree[T], error) {
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Interval.less(entries[i-1].Interval) {
+			return nil, errors.Errorf("entries not sorted: %s before %s", entries[i-1].Interval, entries[i].Interval)
+		}
+	}
+
+	return buildFromSorted(entries), nil
+}
+
+func buildFromSorted[T any](entries []Entry[T]) *Tree[T] {
+	t := NewIntervalTree[T]()
+
+	// Drop duplicate intervals, keeping the last one.
+	unique := make([]Entry[T], 0, len(entries))
+	for _, e := range entries {
+		if n := len(unique); n > 0 && unique[n-1].Interval.low == e.Interval.low && unique[n-1].Interval.high == e.Interval.high {
+			unique[n-1] = e
+			continue
+		}
+		unique = append(unique, e)
+	}
+
+	if len(unique) == 0 {
+		return t
+	}
+
+	// All leaves of a tree built by picking the middle entry as root end up
+	// at the two deepest levels. Coloring the deepest level red (and the rest
+	// black) gives every path from the root the same number of black nodes.
+	maxDepth := bits.Len(uint(len(unique))) - 1
+
+	t.root = t.build(unique, t.sentinel, 0, maxDepth)
+	t.size = len(unique)
+	t.root.color = black
+
+	return t
+}
+
+func (t *Tree[T]) build(entries []Entry[T], parent *node[T], depth, maxDepth int) *node[T] {
+	if len(entries) == 0 {
+		return t.sentinel
+	}
+
+	mid := len(entries) / 2
+
+	z := t.newLeaf(entries[mid].Interval, entries[mid].Payload)
+	z.parent = parent
+	z.color = black
+	if depth == maxDepth {
+		z.color = red
+	}
+
+	z.left = t.build(entries[:mid], z, depth+1, maxDepth)
+	z.right = t.build(entries[mid+1:], z, depth+1, maxDepth)
+
+	t.updateMax(z)
+
+	return z
+}
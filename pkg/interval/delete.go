@@ -3,7 +3,7 @@
 package interval
 
 // Delete deletes a node with the given key.
-func (t *Tree) Delete(key Interval) {
+func (t *Tree[T]) Delete(key Interval) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
@@ -16,14 +16,14 @@ func (t *Tree) Delete(key Interval) {
 	}
 }
 
-func (t *Tree) delete(z *node) {
+func (t *Tree[T]) delete(z *node[T]) {
 	var (
 		// y is either removed or moved in tree
 		y = z
 		// If the color changes, we need to fix it
 		yOriginalColor = y.color
 		// This node moves into y's original position
-		x *node
+		x *node[T]
 	)
 
 	switch {
@@ -57,14 +57,18 @@ func (t *Tree) delete(z *node) {
 		y.color = z.color
 	}
 
-	t.recalcMax(x)
+	// x may be the sentinel, start from its parent which is always set to
+	// the lowest node whose subtree changed.
+	t.recalcMax(x.parent)
+
+	t.size--
 
 	if yOriginalColor == black {
 		t.fixupDelete(x)
 	}
 }
 
-func (t *Tree) transplant(u, v *node) {
+func (t *Tree[T]) transplant(u, v *node[T]) {
 	switch {
 	case u.parent == t.sentinel:
 		t.root = v
@@ -77,7 +81,7 @@ func (t *Tree) transplant(u, v *node) {
 	v.parent = u.parent
 }
 
-func (t *Tree) fixupDelete(x *node) {
+func (t *Tree[T]) fixupDelete(x *node[T]) {
 	for x != t.root && x.color == black {
 		if x == x.parent.left {
 			w := x.parent.right
@@ -0,0 +1,334 @@
+package interval
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"hash"
+	"hash/crc32"
+	"io"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// Trees are serialized in the following format (version 1), all integers are
+// big-endian:
+//
+//	size  field
+//	8     magic "IPCKTREE"
+//	2     format version
+//	4     number of payloads P in the payload table
+//	4     length L of the payload table in bytes
+//	L     payload table, the P payloads as a gob encoded []T
+//	4     number of ranges N
+//	12*N  ranges sorted by interval, each as: low IP, high IP, payload index
+//	4     CRC-32 (IEEE) checksum of all preceding bytes
+//
+// Identical payloads are only stored once in the payload table if they are
+// comparable. Payloads must be encodable with encoding/gob. Trees never hold
+// the same interval twice, but entries written by WriteEntries may.
+
+const (
+	encodingMagic   = "IPCKTREE"
+	encodingVersion = 1
+)
+
+// WriteTo writes the tree to w in the format described above. It implements
+// io.WriterTo.
+func (t *Tree[T]) WriteTo(w io.Writer) (int64, error) {
+	var entries []Entry[T]
+
+	t.Ascend(func(r Result[T]) bool {
+		entries = append(entries, Entry[T]{Interval: r.Interval, Payload: r.Payload})
+		return true
+	})
+
+	return WriteEntries(w, entries)
+}
+
+// ReadFrom replaces the contents of the tree with a tree read from r, written
+// by WriteTo. It implements io.ReaderFrom.
+func (t *Tree[T]) ReadFrom(r io.Reader) (int64, error) {
+	entries, n, err := ReadEntries[T](r)
+	if err != nil {
+		return n, err
+	}
+
+	read, err := BuildFromSorted(entries)
+	if err != nil {
+		return n, err
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.root, t.sentinel, t.size = read.root, read.sentinel, read.size
+
+	return n, nil
+}
+
+// MarshalBinary returns the tree in the format described above. It implements
+// encoding.BinaryMarshaler.
+func (t *Tree[T]) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := t.WriteTo(&buf)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary replaces the contents of the tree with the tree in data. It
+// implements encoding.BinaryUnmarshaler.
+func (t *Tree[T]) UnmarshalBinary(data []byte) error {
+	_, err := t.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// WriteEntries writes entries to w in the same format as Tree.WriteTo. The
+// entries must be sorted by interval, and may hold the same interval more
+// than once.
+func WriteEntries[T any](w io.Writer, entries []Entry[T]) (int64, error) {
+	payloads := make([]T, 0)
+	ids := make([]uint32, len(entries))
+	seen := make(map[interface{}]uint32)
+
+	for i, e := range entries {
+		id, found := payloadID(seen, e.Payload, uint32(len(payloads)))
+		if !found {
+			payloads = append(payloads, e.Payload)
+		}
+		ids[i] = id
+	}
+
+	var table bytes.Buffer
+	if err := gob.NewEncoder(&table).Encode(payloads); err != nil {
+		return 0, errors.Wrap(err, "could not encode payloads")
+	}
+
+	cw := newChecksumWriter(w)
+
+	cw.write([]byte(encodingMagic))
+	cw.writeUint16(encodingVersion)
+	cw.writeUint32(uint32(len(payloads)))
+	cw.writeUint32(uint32(table.Len()))
+	cw.write(table.Bytes())
+	cw.writeUint32(uint32(len(entries)))
+	for i, e := range entries {
+		cw.writeUint32(e.Interval.low)
+		cw.writeUint32(e.Interval.high)
+		cw.writeUint32(ids[i])
+	}
+	cw.writeUint32(cw.hash.Sum32())
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+
+	return cw.n, errors.Wrap(cw.err, "could not write tree")
+}
+
+// payloadID returns the ID of the payload in seen, or adds it with the given
+// next ID. Payloads which can't be used as map keys, e.g. an interface
+// holding a slice, are never found and always get the next ID.
+func payloadID(seen map[interface{}]uint32, payload interface{}, next uint32) (id uint32, found bool) {
+	if !isComparable(reflect.ValueOf(payload)) {
+		return next, false
+	}
+
+	if id, found := seen[payload]; found {
+		return id, true
+	}
+	seen[payload] = next
+	return next, false
+}
+
+// isComparable returns true if v can be used as a map key. Unlike
+// reflect.Type.Comparable it looks at the values held by interfaces, so a
+// struct with an interface field holding a slice isn't comparable.
+func isComparable(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Invalid:
+		// A nil interface.
+		return true
+	case reflect.Interface:
+		return isComparable(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !isComparable(v.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isComparable(v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return v.Type().Comparable()
+	}
+}
+
+// ReadEntries reads entries written by WriteEntries or Tree.WriteTo from r,
+// returning them sorted by interval.
+func ReadEntries[T any](r io.Reader) ([]Entry[T], int64, error) {
+	cr := newChecksumReader(r)
+
+	magic := make([]byte, len(encodingMagic))
+	cr.read(magic)
+	if cr.err == nil && string(magic) != encodingMagic {
+		return nil, cr.n, errors.New("not a serialized interval tree")
+	}
+
+	if version := cr.readUint16(); cr.err == nil && version != encodingVersion {
+		return nil, cr.n, errors.Errorf("unsupported interval tree format version: %d", version)
+	}
+
+	numPayloads := cr.readUint32()
+	table := cr.readBytes(int64(cr.readUint32()))
+
+	var payloads []T
+	if cr.err == nil {
+		if err := gob.NewDecoder(bytes.NewReader(table)).Decode(&payloads); err != nil {
+			return nil, cr.n, errors.Wrap(err, "could not decode payloads")
+		}
+		if uint32(len(payloads)) != numPayloads {
+			return nil, cr.n, errors.Errorf("expected %d payloads, got %d", numPayloads, len(payloads))
+		}
+	}
+
+	numEntries := cr.readUint32()
+	var entries []Entry[T]
+
+	// Read all ranges at once, without reading past them.
+	ranges := cr.readBytes(12 * int64(numEntries))
+
+	if cr.err == nil {
+		entries = make([]Entry[T], 0, len(ranges)/12)
+
+		for i := 0; i < len(ranges); i += 12 {
+			low := binary.BigEndian.Uint32(ranges[i:])
+			high := binary.BigEndian.Uint32(ranges[i+4:])
+			id := binary.BigEndian.Uint32(ranges[i+8:])
+
+			if low > high || id >= uint32(len(payloads)) {
+				return nil, cr.n, errors.Errorf("invalid range %d: [%d - %d] with payload %d", i/12, low, high, id)
+			}
+			entries = append(entries, Entry[T]{Interval: newInterval(low, high), Payload: payloads[id]})
+		}
+	}
+
+	sum := cr.hash.Sum32()
+	if checksum := cr.readUint32(); cr.err == nil && checksum != sum {
+		return nil, cr.n, errors.New("checksum mismatch, serialized interval tree is corrupt")
+	}
+
+	if cr.err != nil {
+		return nil, cr.n, errors.Wrap(cr.err, "could not read tree")
+	}
+
+	for i := 1; i < len(entries); i++ {
+		if entries[i].Interval.less(entries[i-1].Interval) {
+			return nil, cr.n, errors.Errorf("ranges not sorted: %s before %s", entries[i-1].Interval, entries[i].Interval)
+		}
+	}
+
+	return entries, cr.n, nil
+}
+
+// checksumWriter keeps track of the first error, the number of bytes written
+// and a checksum of them.
+type checksumWriter struct {
+	w    *bufio.Writer
+	hash hash.Hash32
+	n    int64
+	err  error
+	buf  [4]byte
+}
+
+func newChecksumWriter(w io.Writer) *checksumWriter {
+	return &checksumWriter{w: bufio.NewWriter(w), hash: crc32.NewIEEE()}
+}
+
+func (cw *checksumWriter) write(b []byte) {
+	if cw.err != nil {
+		return
+	}
+	n, err := cw.w.Write(b)
+	cw.hash.Write(b[:n])
+	cw.n += int64(n)
+	cw.err = err
+}
+
+func (cw *checksumWriter) writeUint16(v uint16) {
+	binary.BigEndian.PutUint16(cw.buf[:2], v)
+	cw.write(cw.buf[:2])
+}
+
+func (cw *checksumWriter) writeUint32(v uint32) {
+	binary.BigEndian.PutUint32(cw.buf[:], v)
+	cw.write(cw.buf[:])
+}
+
+// checksumReader keeps track of the first error, the number of bytes read
+// and a checksum of them. It never reads past the bytes asked for, so several
+// trees can be read one after the other from the same reader.
+type checksumReader struct {
+	r    io.Reader
+	hash hash.Hash32
+	n    int64
+	err  error
+	buf  [4]byte
+}
+
+func newChecksumReader(r io.Reader) *checksumReader {
+	return &checksumReader{r: r, hash: crc32.NewIEEE()}
+}
+
+func (cr *checksumReader) read(b []byte) {
+	if cr.err != nil {
+		return
+	}
+	n, err := io.ReadFull(cr.r, b)
+	cr.hash.Write(b[:n])
+	cr.n += int64(n)
+	cr.err = err
+}
+
+// readBytes reads n bytes. They are read as they come rather than allocated
+// up front, so a corrupt length can't allocate much more than the input
+// holds.
+func (cr *checksumReader) readBytes(n int64) []byte {
+	if cr.err != nil {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	read, err := io.CopyN(&buf, cr.r, n)
+	cr.hash.Write(buf.Bytes())
+	cr.n += read
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	cr.err = err
+
+	return buf.Bytes()
+}
+
+func (cr *checksumReader) readUint16() uint16 {
+	cr.read(cr.buf[:2])
+	if cr.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(cr.buf[:2])
+}
+
+func (cr *checksumReader) readUint32() uint32 {
+	cr.read(cr.buf[:])
+	if cr.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(cr.buf[:])
+}
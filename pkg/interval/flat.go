@@ -0,0 +1,133 @@
+package interval
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Index is implemented by all structures that can be used to look up IP
+// ranges, i.e. the Tree and the FlatIndex.
+type Index[T any] interface {
+	FindFirstOverlapping(key Interval) (Result[T], error)
+	FindAllOverlapping(key Interval) ([]Result[T], error)
+	LookupBatch(ips []uint32) [][]Result[T]
+	Len() int
+}
+
+var (
+	_ Index[any] = (*Tree[any])(nil)
+	_ Index[any] = (*FlatIndex[any])(nil)
+)
+
+// FlatIndex is an immutable index for read-only workloads. Overlapping
+// intervals are flattened into sorted, non-overlapping segments stored in
+// flat arrays, each pointing to the set of intervals covering it. Lookups
+// are a binary search over the segments, which is a lot more cache friendly
+// than walking a pointer based tree and puts no pressure on the GC.
+type FlatIndex[T any] struct {
+	// Segment i covers starts[i] - ends[i] and is covered by the intervals
+	// in sets[setIDs[i]].
+	starts []uint32
+	ends   []uint32
+	setIDs []int32
+
+	sets    [][]int32
+	results []Result[T]
+}
+
+// NewFlatIndex returns a new FlatIndex containing all entries. As with
+// Build, the last entry wins if the same interval occurs more than once.
+func NewFlatIndex[T any](entries []Entry[T]) *FlatIndex[T] {
+	t := Build(entries)
+	idx := &FlatIndex[T]{results: t.InOrder()}
+
+	interval := func(i int) Interval { return idx.results[i].Interval }
+
+	setIDs := make(map[string]int32)
+	var key strings.Builder
+
+	sweep(len(idx.results), interval, func(start, end uint32, set []int32) {
+		key.Reset()
+		for _, id := range set {
+			key.WriteString(strconv.Itoa(int(id)))
+			key.WriteByte(',')
+		}
+
+		setID, found := setIDs[key.String()]
+		if !found {
+			setID = int32(len(idx.sets))
+			setIDs[key.String()] = setID
+			idx.sets = append(idx.sets, set)
+		}
+
+		idx.starts = append(idx.starts, start)
+		idx.ends = append(idx.ends, end)
+		idx.setIDs = append(idx.setIDs, setID)
+	})
+
+	return idx
+}
+
+// Len returns the number of intervals in the index.
+func (idx *FlatIndex[T]) Len() int {
+	return len(idx.results)
+}
+
+// Segments returns the number of non-overlapping segments in the index.
+func (idx *FlatIndex[T]) Segments() int {
+	return len(idx.starts)
+}
+
+// FindFirstOverlapping returns the payload of the lowest interval that
+// overlaps with the passed key. Returns an ErrNotFound if no overlapping
+// interval is found.
+func (idx *FlatIndex[T]) FindFirstOverlapping(key Interval) (Result[T], error) {
+	for i := idx.segment(key.low); i < len(idx.starts) && idx.starts[i] <= key.high; i++ {
+		if idx.ends[i] >= key.low {
+			return idx.results[idx.sets[idx.setIDs[i]][0]], nil
+		}
+	}
+
+	return Result[T]{}, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
+}
+
+// FindAllOverlapping returns a slice of Result with all intervals overlapping
+// the given interval key, sorted by interval. Returns an ErrNotFound if no
+// overlapping interval is found.
+func (idx *FlatIndex[T]) FindAllOverlapping(key Interval) ([]Result[T], error) {
+	var ids []int32
+
+	for i := idx.segment(key.low); i < len(idx.starts) && idx.starts[i] <= key.high; i++ {
+		if idx.ends[i] >= key.low {
+			ids = append(ids, idx.sets[idx.setIDs[i]]...)
+		}
+	}
+
+	if len(ids) == 0 {
+		return nil, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	res := make([]Result[T], 0, len(ids))
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+		res = append(res, idx.results[id])
+	}
+
+	return res, nil
+}
+
+// segment returns the position of the last segment starting at or before ip,
+// or 0 if there is none.
+func (idx *FlatIndex[T]) segment(ip uint32) int {
+	i := sort.Search(len(idx.starts), func(i int) bool { return idx.starts[i] > ip })
+	if i > 0 {
+		i--
+	}
+	return i
+}
@@ -4,7 +4,7 @@ package interval
 
 // Upsert updates an existing payload, or inserts a new one with the given
 // interval key.
-func (t *Tree) Upsert(key Interval, payload interface{}) {
+func (t *Tree[T]) Upsert(key Interval, payload T) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
@@ -21,7 +21,7 @@ func (t *Tree) Upsert(key Interval, payload interface{}) {
 	}
 }
 
-func (t *Tree) insert(z *node) {
+func (t *Tree[T]) insert(z *node[T]) {
 	var (
 		y = t.sentinel
 		x = t.root
@@ -57,17 +57,19 @@ func (t *Tree) insert(z *node) {
 	z.right = t.sentinel
 	z.color = red
 
+	t.size++
+
 	t.fixupInsert(z)
 }
 
-func (t *Tree) recalcMax(z *node) {
+func (t *Tree[T]) recalcMax(z *node[T]) {
 	for z != t.sentinel {
 		t.updateMax(z)
 		z = z.parent
 	}
 }
 
-func (t *Tree) fixupInsert(z *node) {
+func (t *Tree[T]) fixupInsert(z *node[T]) {
 	for z.parent.color == red {
 		if z.parent == z.parent.parent.left {
 			y := z.parent.parent.right
@@ -44,6 +44,11 @@ func (i Interval) Stop() uint32 {
 	return i.high
 }
 
+// Size returns the number of IPs in the interval.
+func (i Interval) Size() uint64 {
+	return uint64(i.high) - uint64(i.low) + 1
+}
+
 func (i Interval) less(x Interval) bool {
 	return i.low < x.low || i.low == x.low && i.high < x.high
 }
@@ -0,0 +1,41 @@
+package interval
+
+// Ascend calls fn for every interval in the tree in ascending order, until fn
+// returns false. Unlike InOrder it doesn't allocate a slice with all
+// intervals, so it can be used to walk very large trees. The tree is read
+// locked during the walk, so fn must not modify it.
+func (t *Tree[T]) Ascend(fn func(r Result[T]) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	t.ascend(t.min(t.root), ^uint32(0), fn)
+}
+
+// AscendRange is like Ascend, but only visits intervals with a lower bound
+// between from and to (inclusive).
+func (t *Tree[T]) AscendRange(from, to uint32, fn func(r Result[T]) bool) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	// Find the lowest node starting at or after from.
+	first := t.sentinel
+	for x := t.root; x != t.sentinel; {
+		if x.key.low >= from {
+			first = x
+			x = x.left
+		} else {
+			x = x.right
+		}
+	}
+
+	t.ascend(first, to, fn)
+}
+
+func (t *Tree[T]) ascend(z *node[T], to uint32, fn func(r Result[T]) bool) {
+	for z != t.sentinel && z.key.low <= to {
+		if !fn(Result[T]{Interval: z.key, Payload: z.payload}) {
+			return
+		}
+		z = t.successor(z)
+	}
+}
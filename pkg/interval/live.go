@@ -0,0 +1,100 @@
+package interval
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Live holds the current version of an immutable FlatIndex. Readers grab the
+// current version with a single atomic load and never block, while writers
+// build a new version and publish it atomically, so reloading data never
+// stalls lookups. Readers that loaded an older version keep using it until
+// they're done with it.
+type Live[T any] struct {
+	current atomic.Pointer[FlatIndex[T]]
+	// Serializes writers, readers never touch it.
+	writer sync.Mutex
+}
+
+var _ Index[any] = (*Live[any])(nil)
+
+// NewLive returns a Live index with the given initial version, or an empty
+// one if idx is nil.
+func NewLive[T any](idx *FlatIndex[T]) *Live[T] {
+	if idx == nil {
+		idx = NewFlatIndex[T](nil)
+	}
+
+	l := &Live[T]{}
+	l.current.Store(idx)
+	return l
+}
+
+// Load returns the current version of the index.
+func (l *Live[T]) Load() *FlatIndex[T] {
+	return l.current.Load()
+}
+
+// Store publishes a new version of the index.
+func (l *Live[T]) Store(idx *FlatIndex[T]) {
+	l.writer.Lock()
+	defer l.writer.Unlock()
+
+	l.current.Store(idx)
+}
+
+// Update copies the current version into a new tree, lets fn modify it and
+// publishes the result as the new version. Concurrent updates are applied one
+// after the other.
+func (l *Live[T]) Update(fn func(t *Tree[T])) {
+	l.writer.Lock()
+	defer l.writer.Unlock()
+
+	t := Build(l.current.Load().entries())
+	fn(t)
+	l.current.Store(t.Snapshot())
+}
+
+// FindFirstOverlapping looks up key in the current version, see
+// FlatIndex.FindFirstOverlapping.
+func (l *Live[T]) FindFirstOverlapping(key Interval) (Result[T], error) {
+	return l.Load().FindFirstOverlapping(key)
+}
+
+// FindAllOverlapping looks up key in the current version, see
+// FlatIndex.FindAllOverlapping.
+func (l *Live[T]) FindAllOverlapping(key Interval) ([]Result[T], error) {
+	return l.Load().FindAllOverlapping(key)
+}
+
+// LookupBatch looks up all IPs in the current version, see
+// FlatIndex.LookupBatch.
+func (l *Live[T]) LookupBatch(ips []uint32) [][]Result[T] {
+	return l.Load().LookupBatch(ips)
+}
+
+// Len returns the number of intervals in the current version.
+func (l *Live[T]) Len() int {
+	return l.Load().Len()
+}
+
+// Snapshot returns an immutable copy of the tree as a FlatIndex, which can be
+// read concurrently without any locking.
+func (t *Tree[T]) Snapshot() *FlatIndex[T] {
+	var entries []Entry[T]
+
+	t.Ascend(func(r Result[T]) bool {
+		entries = append(entries, Entry[T]{Interval: r.Interval, Payload: r.Payload})
+		return true
+	})
+
+	return NewFlatIndex(entries)
+}
+
+func (idx *FlatIndex[T]) entries() []Entry[T] {
+	entries := make([]Entry[T], 0, len(idx.results))
+	for _, r := range idx.results {
+		entries = append(entries, Entry[T]{Interval: r.Interval, Payload: r.Payload})
+	}
+	return entries
+}
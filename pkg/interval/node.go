@@ -2,12 +2,12 @@
 // All cred goes to the author.
 package interval
 
-type node struct {
+type node[T any] struct {
 	key     Interval
 	color   color
-	left    *node
-	right   *node
-	parent  *node
+	left    *node[T]
+	right   *node[T]
+	parent  *node[T]
 	max     uint32
-	payload interface{}
+	payload T
 }
@@ -8,29 +8,28 @@ import (
 
 const noIntervalErrMsg = "no interval found for %q"
 
-type inorderResult struct {
-	nodes   map[Interval]*node
-	results []Result
+type inorderResult[T any] struct {
+	results []Result[T]
 }
 
 // FindFirstOverlapping returns the payload of the first interval that overlaps
 // with the passed key. Returns an ErrNotFound if no overlapping interval is
 // found.
-func (t *Tree) FindFirstOverlapping(key Interval) (Result, error) {
+func (t *Tree[T]) FindFirstOverlapping(key Interval) (Result[T], error) {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
 	if t.root == t.sentinel {
-		return Result{}, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
+		return Result[T]{}, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
 	}
 
 	n := t.search(t.root, key)
 
 	if n == t.sentinel {
-		return Result{}, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
+		return Result[T]{}, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
 	}
 
-	return Result{
+	return Result[T]{
 		Interval: n.key,
 		Payload:  n.payload,
 	}, nil
@@ -39,7 +38,7 @@ func (t *Tree) FindFirstOverlapping(key Interval) (Result, error) {
 // FindAllOverlapping returns a slice of Result with all intervals overlapping
 // the given interval key. Returns an ErrNotFound if no overlapping interval is
 // found.
-func (t *Tree) FindAllOverlapping(key Interval) ([]Result, error) {
+func (t *Tree[T]) FindAllOverlapping(key Interval) ([]Result[T], error) {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
@@ -47,9 +46,8 @@ func (t *Tree) FindAllOverlapping(key Interval) ([]Result, error) {
 		return nil, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
 	}
 
-	res := &inorderResult{
-		nodes:   make(map[Interval]*node),
-		results: make([]Result, 0),
+	res := &inorderResult[T]{
+		results: make([]Result[T], 0),
 	}
 
 	t.searchInorder(t.root, key, res)
@@ -63,22 +61,22 @@ func (t *Tree) FindAllOverlapping(key Interval) ([]Result, error) {
 
 // FindExact returns the exactly matching Result for the given key interval.
 // Returns an ErrNotFound if not found.
-func (t *Tree) FindExact(key Interval) (Result, error) {
+func (t *Tree[T]) FindExact(key Interval) (Result[T], error) {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
 	if n := t.findExact(key); n != nil {
-		return Result{
+		return Result[T]{
 			Interval: n.key,
 			Payload:  n.payload,
 		}, nil
 	}
 
-	return Result{}, ErrNotFound(fmt.Sprintf("interval %q does not exist", key))
+	return Result[T]{}, ErrNotFound(fmt.Sprintf("interval %q does not exist", key))
 }
 
 // InOrder returns an ordered list of all entries.
-func (t *Tree) InOrder() []Result {
+func (t *Tree[T]) InOrder() []Result[T] {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
@@ -86,7 +84,7 @@ func (t *Tree) InOrder() []Result {
 		return nil
 	}
 
-	res := make([]Result, 0)
+	res := make([]Result[T], 0)
 
 	t.resultsInorder(t.root, &res)
 
@@ -97,28 +95,180 @@ func (t *Tree) InOrder() []Result {
 // passed key interval. Returns ErrNotFound if the no successor can be found
 // (either because the passed key doesn't yield a node, or if the found node
 // is highest in the Tree.
-func (t *Tree) Successor(key Interval) (Result, error) {
+func (t *Tree[T]) Successor(key Interval) (Result[T], error) {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
 	z := t.findExact(key)
 	if z == nil {
-		return Result{}, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
+		return Result[T]{}, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
 	}
 
 	n := t.successor(z)
 
 	if n == t.sentinel {
-		return Result{}, ErrNotFound(fmt.Sprintf("node with interval %q is the highest in the tree", key))
+		return Result[T]{}, ErrNotFound(fmt.Sprintf("node with interval %q is the highest in the tree", key))
 	}
 
-	return Result{
+	return Result[T]{
 		Interval: n.key,
 		Payload:  n.payload,
 	}, nil
 }
 
-func (t *Tree) successor(z *node) *node {
+// Predecessor returns the next lowest neighbour (key-wise) of the Node with the
+// passed key interval. Returns ErrNotFound if the no predecessor can be found
+// (either because the passed key doesn't yield a node, or if the found node
+// is lowest in the Tree.
+func (t *Tree[T]) Predecessor(key Interval) (Result[T], error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	z := t.findExact(key)
+	if z == nil {
+		return Result[T]{}, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
+	}
+
+	n := t.predecessor(z)
+
+	if n == t.sentinel {
+		return Result[T]{}, ErrNotFound(fmt.Sprintf("node with interval %q is the lowest in the tree", key))
+	}
+
+	return Result[T]{
+		Interval: n.key,
+		Payload:  n.payload,
+	}, nil
+}
+
+// StabCount returns the number of intervals overlapping the given interval
+// key, e.g. the number of ranges containing an IP, without allocating any
+// results.
+func (t *Tree[T]) StabCount(key Interval) int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if t.root == t.sentinel {
+		return 0
+	}
+
+	return t.stabCount(t.root, key)
+}
+
+func (t *Tree[T]) stabCount(z *node[T], key Interval) int {
+	var n int
+
+	if z.left != t.sentinel && z.left.max >= key.low {
+		n += t.stabCount(z.left, key)
+	}
+
+	if z.key.overlaps(key) {
+		n++
+	}
+
+	// Everything to the right starts after z, so there's nothing more to
+	// find if z already starts after the key.
+	if z.right != t.sentinel && z.right.max >= key.low && z.key.low <= key.high {
+		n += t.stabCount(z.right, key)
+	}
+
+	return n
+}
+
+// FindNarrowestOverlapping returns the smallest interval overlapping the given
+// interval key, e.g. the most specific range containing an IP (like a longest
+// prefix match when all ranges are CIDRs). Ties go to the lowest interval.
+// Returns an ErrNotFound if no overlapping interval is found.
+func (t *Tree[T]) FindNarrowestOverlapping(key Interval) (Result[T], error) {
+	return t.findBestOverlapping(key, func(a, b Interval) bool { return a.Size() < b.Size() })
+}
+
+// FindWidestOverlapping returns the largest interval overlapping the given
+// interval key, e.g. the broadest range containing an IP. Ties go to the
+// lowest interval. Returns an ErrNotFound if no overlapping interval is found.
+func (t *Tree[T]) FindWidestOverlapping(key Interval) (Result[T], error) {
+	return t.findBestOverlapping(key, func(a, b Interval) bool { return a.Size() > b.Size() })
+}
+
+func (t *Tree[T]) findBestOverlapping(key Interval, better func(a, b Interval) bool) (Result[T], error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	best := t.sentinel
+	if t.root != t.sentinel {
+		t.bestOverlapping(t.root, key, better, &best)
+	}
+
+	if best == t.sentinel {
+		return Result[T]{}, ErrNotFound(fmt.Sprintf(noIntervalErrMsg, key))
+	}
+
+	return Result[T]{
+		Interval: best.key,
+		Payload:  best.payload,
+	}, nil
+}
+
+// bestOverlapping walks all intervals overlapping key in order, just like
+// stabCount, keeping the best one in best.
+func (t *Tree[T]) bestOverlapping(z *node[T], key Interval, better func(a, b Interval) bool, best **node[T]) {
+	if z.left != t.sentinel && z.left.max >= key.low {
+		t.bestOverlapping(z.left, key, better, best)
+	}
+
+	if z.key.overlaps(key) && (*best == t.sentinel || better(z.key, (*best).key)) {
+		*best = z
+	}
+
+	if z.right != t.sentinel && z.right.max >= key.low && z.key.low <= key.high {
+		t.bestOverlapping(z.right, key, better, best)
+	}
+}
+
+// Narrowest returns the smallest interval in results, ties going to the first
+// one. results must not be empty.
+func Narrowest[T any](results []Result[T]) Result[T] {
+	best := results[0]
+	for _, r := range results[1:] {
+		if r.Interval.Size() < best.Interval.Size() {
+			best = r
+		}
+	}
+	return best
+}
+
+// Widest returns the largest interval in results, ties going to the first
+// one. results must not be empty.
+func Widest[T any](results []Result[T]) Result[T] {
+	best := results[0]
+	for _, r := range results[1:] {
+		if r.Interval.Size() > best.Interval.Size() {
+			best = r
+		}
+	}
+	return best
+}
+
+func (t *Tree[T]) predecessor(z *node[T]) *node[T] {
+	if z == t.sentinel {
+		return nil
+	}
+
+	if z.left != t.sentinel {
+		return t.max(z.left)
+	}
+
+	parent := z.parent
+
+	for parent != t.sentinel && z == parent.left {
+		z = parent
+		parent = z.parent
+	}
+
+	return parent
+}
+
+func (t *Tree[T]) successor(z *node[T]) *node[T] {
 	if z == t.sentinel {
 		return nil
 	}
@@ -137,7 +287,7 @@ func (t *Tree) successor(z *node) *node {
 	return parent
 }
 
-func (t *Tree) resultsInorder(z *node, res *[]Result) {
+func (t *Tree[T]) resultsInorder(z *node[T], res *[]Result[T]) {
 	if z == t.sentinel {
 		return
 	}
@@ -146,7 +296,7 @@ func (t *Tree) resultsInorder(z *node, res *[]Result) {
 		t.resultsInorder(z.left, res)
 	}
 
-	*res = append(*res, Result{
+	*res = append(*res, Result[T]{
 		Interval: z.key,
 		Payload:  z.payload,
 	})
@@ -156,26 +306,27 @@ func (t *Tree) resultsInorder(z *node, res *[]Result) {
 	}
 }
 
-func (t *Tree) findExact(key Interval) *node {
-	if t.root == t.sentinel {
-		return nil
-	}
+// findExact finds the node with the given key by descending the tree the same
+// way insert does, i.e. in O(log n).
+func (t *Tree[T]) findExact(key Interval) *node[T] {
+	x := t.root
 
-	res := &inorderResult{
-		nodes:   make(map[Interval]*node),
-		results: make([]Result, 0),
-	}
-
-	t.searchInorder(t.root, key, res)
+	for x != t.sentinel {
+		if x.key.low == key.low && x.key.high == key.high {
+			return x
+		}
 
-	if n, ok := res.nodes[key]; ok {
-		return n
+		if key.less(x.key) {
+			x = x.left
+		} else {
+			x = x.right
+		}
 	}
 
 	return nil
 }
 
-func (t *Tree) searchInorder(z *node, key Interval, result *inorderResult) {
+func (t *Tree[T]) searchInorder(z *node[T], key Interval, result *inorderResult[T]) {
 	if result == nil {
 		panic("result can't be nil")
 	}
@@ -185,8 +336,7 @@ func (t *Tree) searchInorder(z *node, key Interval, result *inorderResult) {
 	}
 
 	if z.key.overlaps(key) {
-		result.nodes[z.key] = z
-		result.results = append(result.results, Result{
+		result.results = append(result.results, Result[T]{
 			Interval: z.key,
 			Payload:  z.payload,
 		})
@@ -197,7 +347,7 @@ func (t *Tree) searchInorder(z *node, key Interval, result *inorderResult) {
 	}
 }
 
-func (t *Tree) search(x *node, key Interval) *node {
+func (t *Tree[T]) search(x *node[T], key Interval) *node[T] {
 	for x != t.sentinel && !key.overlaps(x.key) {
 		if x.left != t.sentinel && x.left.max >= key.low {
 			x = x.left
@@ -0,0 +1,146 @@
+package interval
+
+import (
+	"sort"
+)
+
+// Segment is a range of IPs covered by exactly the same set of payloads.
+type Segment[T any] struct {
+	Interval Interval
+	Payloads []T
+}
+
+// Segments is a sorted list of non-overlapping segments, e.g. as returned by
+// Flatten. Looking up a single IP returns the payloads of all intervals
+// covering it at once.
+type Segments[T any] []Segment[T]
+
+// Find returns the segment containing ip. Returns false if ip isn't covered
+// by any segment.
+func (s Segments[T]) Find(ip uint32) (Segment[T], bool) {
+	i := sort.Search(len(s), func(i int) bool { return s[i].Interval.high >= ip })
+	if i < len(s) && s[i].Interval.low <= ip {
+		return s[i], true
+	}
+	return Segment[T]{}, false
+}
+
+// Flatten turns entries, which may overlap, into sorted, non-overlapping
+// segments, each carrying the set of payloads of all entries covering it in
+// the order they first occur in entries. Unlike Build, entries with the same
+// interval are all kept. Adjacent segments covered by the same set of
+// payloads are merged, e.g. two adjacent ranges of the same list end up in a
+// single segment. Tree.Flatten and FlatIndex.Flatten don't merge segments, as
+// their payloads can't be compared.
+func Flatten[T comparable](entries []Entry[T]) Segments[T] {
+	var segments Segments[T]
+
+	interval := func(i int) Interval { return entries[i].Interval }
+
+	sweep(len(entries), interval, func(start, end uint32, active []int32) {
+		seen := make(map[T]bool, len(active))
+		payloads := make([]T, 0, len(active))
+		for _, id := range active {
+			if p := entries[id].Payload; !seen[p] {
+				seen[p] = true
+				payloads = append(payloads, p)
+			}
+		}
+
+		if n := len(segments); n > 0 && segments[n-1].Interval.high+1 == start && samePayloads(segments[n-1].Payloads, seen) {
+			segments[n-1].Interval = newInterval(segments[n-1].Interval.low, end)
+			return
+		}
+
+		segments = append(segments, Segment[T]{Interval: newInterval(start, end), Payloads: payloads})
+	})
+
+	return segments
+}
+
+func samePayloads[T comparable](payloads []T, set map[T]bool) bool {
+	if len(payloads) != len(set) {
+		return false
+	}
+	for _, p := range payloads {
+		if !set[p] {
+			return false
+		}
+	}
+	return true
+}
+
+// Flatten returns the tree as sorted, non-overlapping segments, each carrying
+// the payloads of all intervals covering it, sorted by interval. Unlike the
+// Flatten function, adjacent segments are never merged: each segment is a
+// range of IPs covered by exactly the same intervals, even if another
+// interval next to it holds an equal payload.
+func (t *Tree[T]) Flatten() Segments[T] {
+	return t.Snapshot().Flatten()
+}
+
+// Flatten returns the segments of the index, each carrying the payloads of
+// all intervals covering it, sorted by interval. Like Tree.Flatten, adjacent
+// segments with equal payloads aren't merged.
+func (idx *FlatIndex[T]) Flatten() Segments[T] {
+	segments := make(Segments[T], 0, len(idx.starts))
+
+	for i := range idx.starts {
+		set := idx.sets[idx.setIDs[i]]
+
+		payloads := make([]T, 0, len(set))
+		for _, id := range set {
+			payloads = append(payloads, idx.results[id].Payload)
+		}
+
+		segments = append(segments, Segment[T]{Interval: newInterval(idx.starts[i], idx.ends[i]), Payloads: payloads})
+	}
+
+	return segments
+}
+
+// sweep calls fn for each maximal range of IPs covered by the same, non-empty
+// set of intervals, passing the sorted indexes of the intervals covering it.
+func sweep(n int, interval func(i int) Interval, fn func(start, end uint32, active []int32)) {
+	type event struct {
+		pos uint64
+		id  int32
+		add bool
+	}
+
+	events := make([]event, 0, n*2)
+	for i := 0; i < n; i++ {
+		in := interval(i)
+		events = append(events,
+			event{pos: uint64(in.low), id: int32(i), add: true},
+			event{pos: uint64(in.high) + 1, id: int32(i)},
+		)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].pos < events[j].pos })
+
+	active := make(map[int32]bool)
+
+	for i := 0; i < len(events); {
+		pos := events[i].pos
+
+		for ; i < len(events) && events[i].pos == pos; i++ {
+			if events[i].add {
+				active[events[i].id] = true
+			} else {
+				delete(active, events[i].id)
+			}
+		}
+
+		if len(active) == 0 || i == len(events) {
+			continue
+		}
+
+		ids := make([]int32, 0, len(active))
+		for id := range active {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		fn(uint32(pos), uint32(events[i].pos-1), ids)
+	}
+}
@@ -0,0 +1,113 @@
+package interval
+
+import (
+	"github.com/anrid/ipcheck/pkg/iputil"
+)
+
+// MergeFunc merges the payloads of two intervals, a from the tree the
+// operation is called on and b from the other tree.
+type MergeFunc[T any] func(a, b T) T
+
+// Union returns a new tree containing all intervals from both trees. The
+// payloads of intervals found in both trees are merged using merge, or
+// replaced by the payload from other if merge is nil.
+func (t *Tree[T]) Union(other *Tree[T], merge MergeFunc[T]) *Tree[T] {
+	res := NewIntervalTree[T]()
+
+	for _, r := range t.InOrder() {
+		res.Upsert(r.Interval, r.Payload)
+	}
+
+	for _, r := range other.InOrder() {
+		if n := res.findExact(r.Interval); n != nil && merge != nil {
+			n.payload = merge(n.payload, r.Payload)
+			continue
+		}
+		res.Upsert(r.Interval, r.Payload)
+	}
+
+	return res
+}
+
+// Intersection returns a new tree containing the overlapping part of every
+// pair of overlapping intervals from both trees, e.g. all parts of blocklist
+// ranges that fall inside our own allocated IP space. Payloads are merged
+// using merge, or taken from this tree if merge is nil. If several pairs
+// overlap in exactly the same range, their merged payloads are merged again.
+func (t *Tree[T]) Intersection(other *Tree[T], merge MergeFunc[T]) *Tree[T] {
+	res := NewIntervalTree[T]()
+
+	for _, a := range t.InOrder() {
+		overlapping, err := other.FindAllOverlapping(a.Interval)
+		if err != nil {
+			continue
+		}
+
+		for _, b := range overlapping {
+			low, high := a.Interval.low, a.Interval.high
+			if b.Interval.low > low {
+				low = b.Interval.low
+			}
+			if b.Interval.high < high {
+				high = b.Interval.high
+			}
+
+			payload := a.Payload
+			if merge != nil {
+				payload = merge(a.Payload, b.Payload)
+			}
+
+			key := newInterval(low, high)
+			if n := res.findExact(key); n != nil && merge != nil {
+				n.payload = merge(n.payload, payload)
+				continue
+			}
+			res.Upsert(key, payload)
+		}
+	}
+
+	return res
+}
+
+// Difference returns a new tree containing the parts of every interval in
+// this tree not covered by any interval in other, e.g. a blocklist minus our
+// allowlist. Payloads are kept as they are.
+func (t *Tree[T]) Difference(other *Tree[T]) *Tree[T] {
+	res := NewIntervalTree[T]()
+
+	for _, a := range t.InOrder() {
+		overlapping, err := other.FindAllOverlapping(a.Interval)
+		if err != nil {
+			res.Upsert(a.Interval, a.Payload)
+			continue
+		}
+
+		// Overlapping intervals are sorted by their lower bound, cut them
+		// out of a one by one.
+		next := uint64(a.Interval.low)
+
+		for _, b := range overlapping {
+			if uint64(b.Interval.low) > next {
+				res.Upsert(newInterval(uint32(next), b.Interval.low-1), a.Payload)
+			}
+			if uint64(b.Interval.high)+1 > next {
+				next = uint64(b.Interval.high) + 1
+			}
+		}
+
+		if next <= uint64(a.Interval.high) {
+			res.Upsert(newInterval(uint32(next), a.Interval.high), a.Payload)
+		}
+	}
+
+	return res
+}
+
+func newInterval(low, high uint32) Interval {
+	return Interval{
+		IPRangeMin: iputil.Long2IP(low),
+		IPRangeMax: iputil.Long2IP(high),
+		low:        low,
+		high:       high,
+	}
+}
@@ -10,9 +10,8 @@ import (
 type color int
 
 const (
-	red             color  = 0
-	black           color  = 1
-	sentinelPayload string = "sentinel"
+	red   color = 0
+	black color = 1
 )
 
 type ErrNotFound string
@@ -22,24 +21,25 @@ func (e ErrNotFound) Error() string {
 }
 
 // Tree represents an Interval tree with a root node and Mutex to
-// protect concurrent access.
-type Tree struct {
+// protect concurrent access. Each interval carries a payload of type T.
+type Tree[T any] struct {
 	lock     sync.RWMutex
-	root     *node
-	sentinel *node
+	root     *node[T]
+	sentinel *node[T]
+	size     int
 }
 
 // Result is a search result when looking up an interval in the tree.
-type Result struct {
+type Result[T any] struct {
 	Interval Interval
-	Payload  interface{}
+	Payload  T
 }
 
 // NewIntervalTree returns an initialized but empty interval tree.
-func NewIntervalTree() *Tree {
-	sentinel := &node{color: black, payload: sentinelPayload}
+func NewIntervalTree[T any]() *Tree[T] {
+	sentinel := &node[T]{color: black}
 
-	return &Tree{
+	return &Tree[T]{
 		lock:     sync.RWMutex{},
 		root:     sentinel,
 		sentinel: sentinel,
@@ -48,15 +48,15 @@ func NewIntervalTree() *Tree {
 
 // Root returns a Result of the payload of the root node of the tree or an
 // ErrNotFound if the tree is empty.
-func (t *Tree) Root() (Result, error) {
+func (t *Tree[T]) Root() (Result[T], error) {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
 	if t.root == t.sentinel {
-		return Result{}, ErrNotFound("tree is empty")
+		return Result[T]{}, ErrNotFound("tree is empty")
 	}
 
-	return Result{
+	return Result[T]{
 		Interval: t.root.key,
 		Payload:  t.root.payload,
 	}, nil
@@ -64,14 +64,14 @@ func (t *Tree) Root() (Result, error) {
 
 // Height returns the height (max depth) of the tree. Returns -1 if the tree
 // has no nodes. A (rooted) tree with only a single node has a height of zero.
-func (t *Tree) Height() int {
+func (t *Tree[T]) Height() int {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
 	return int(t.height(t.root))
 }
 
-func (t *Tree) height(node *node) float64 {
+func (t *Tree[T]) height(node *node[T]) float64 {
 	if node == t.sentinel {
 		return -1
 	}
@@ -81,23 +81,49 @@ func (t *Tree) height(node *node) float64 {
 
 // Min returns a Result of the lowest interval in the tree or an ErrNotFound if
 // the tree is empty.
-func (t *Tree) Min() (Result, error) {
+func (t *Tree[T]) Min() (Result[T], error) {
 	t.lock.RLock()
 	defer t.lock.RUnlock()
 
 	n := t.min(t.root)
 
 	if n == t.sentinel {
-		return Result{}, ErrNotFound("tree is empty")
+		return Result[T]{}, ErrNotFound("tree is empty")
 	}
 
-	return Result{
+	return Result[T]{
 		Interval: n.key,
 		Payload:  n.payload,
 	}, nil
 }
 
-func (t *Tree) rotateLeft(x *node) {
+// Max returns a Result of the highest interval in the tree or an ErrNotFound
+// if the tree is empty.
+func (t *Tree[T]) Max() (Result[T], error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	n := t.max(t.root)
+
+	if n == t.sentinel {
+		return Result[T]{}, ErrNotFound("tree is empty")
+	}
+
+	return Result[T]{
+		Interval: n.key,
+		Payload:  n.payload,
+	}, nil
+}
+
+// Len returns the number of intervals in the tree.
+func (t *Tree[T]) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	return t.size
+}
+
+func (t *Tree[T]) rotateLeft(x *node[T]) {
 	// y's left subtree will be x's right subtree.
 	y := x.right
 	x.right = y.left
@@ -123,9 +149,10 @@ func (t *Tree) rotateLeft(x *node) {
 	x.parent = y
 
 	t.updateMax(x)
+	t.updateMax(y)
 }
 
-func (t *Tree) rotateRight(x *node) {
+func (t *Tree[T]) rotateRight(x *node[T]) {
 	y := x.left
 	x.left = y.right
 
@@ -147,11 +174,12 @@ func (t *Tree) rotateRight(x *node) {
 	y.right = x
 	x.parent = y
 
+	t.updateMax(x)
 	t.updateMax(y)
 }
 
-func (t *Tree) newLeaf(key Interval, p interface{}) *node {
-	return &node{
+func (t *Tree[T]) newLeaf(key Interval, p T) *node[T] {
+	return &node[T]{
 		key:     key,
 		payload: p,
 		left:    t.sentinel,
@@ -160,11 +188,11 @@ func (t *Tree) newLeaf(key Interval, p interface{}) *node {
 	}
 }
 
-// func (t *Tree) isLeaf(z *node) bool {
+// func (t *Tree[T]) isLeaf(z *node[T]) bool {
 // 	return z.left == t.sentinel && z.right == t.sentinel
 // }
 
-func (t *Tree) min(z *node) *node {
+func (t *Tree[T]) min(z *node[T]) *node[T] {
 	for z != t.sentinel && z.left != t.sentinel {
 		z = z.left
 	}
@@ -172,7 +200,15 @@ func (t *Tree) min(z *node) *node {
 	return z
 }
 
-func (t *Tree) updateMax(z *node) {
+func (t *Tree[T]) max(z *node[T]) *node[T] {
+	for z != t.sentinel && z.right != t.sentinel {
+		z = z.right
+	}
+
+	return z
+}
+
+func (t *Tree[T]) updateMax(z *node[T]) {
 	z.max = z.key.high
 
 	if z.right != t.sentinel && z.right.max > z.max {
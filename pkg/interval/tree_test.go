@@ -1,16 +1,23 @@
 package interval
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sync"
 	"testing"
 
+	"github.com/anrid/ipcheck/pkg/iputil"
 	"github.com/stretchr/testify/require"
 )
 
 func TestIntervalTree(t *testing.T) {
 	r := require.New(t)
 
-	tree := NewIntervalTree()
+	tree := NewIntervalTree[string]()
 
 	r1, err := NewInterval("10.10.10.0", "10.20.30.40")
 	if err != nil {
@@ -81,3 +88,768 @@ func TestIntervalTree(t *testing.T) {
 		}
 	}
 }
+
+func TestSetOperations(t *testing.T) {
+	r := require.New(t)
+
+	newTree := func(ranges ...string) *Tree[string] {
+		tree := NewIntervalTree[string]()
+		for i := 0; i < len(ranges); i += 3 {
+			in, err := NewInterval(ranges[i], ranges[i+1])
+			r.NoError(err)
+			tree.Upsert(in, ranges[i+2])
+		}
+		return tree
+	}
+
+	ranges := func(tree *Tree[string]) (res []string) {
+		for _, re := range tree.InOrder() {
+			res = append(res, fmt.Sprintf("%s - %s %s", re.Interval.IPRangeMin, re.Interval.IPRangeMax, re.Payload))
+		}
+		return res
+	}
+
+	concat := func(a, b string) string {
+		return a + "+" + b
+	}
+
+	blocklist := newTree(
+		"10.0.0.0", "10.0.255.255", "firehol",
+		"34.64.0.0", "34.127.255.255", "pushing_inertia",
+		"4.4.4.4", "4.4.4.4", "joost",
+	)
+	allowlist := newTree(
+		"10.0.1.0", "10.0.1.255", "office",
+		"10.0.3.0", "10.0.4.255", "vpn",
+		"4.4.4.4", "4.4.4.4", "dns",
+	)
+
+	r.Equal([]string{
+		"4.4.4.4 - 4.4.4.4 joost+dns",
+		"10.0.0.0 - 10.0.255.255 firehol",
+		"10.0.1.0 - 10.0.1.255 office",
+		"10.0.3.0 - 10.0.4.255 vpn",
+		"34.64.0.0 - 34.127.255.255 pushing_inertia",
+	}, ranges(blocklist.Union(allowlist, concat)))
+
+	r.Equal([]string{
+		"4.4.4.4 - 4.4.4.4 joost+dns",
+		"10.0.1.0 - 10.0.1.255 firehol+office",
+		"10.0.3.0 - 10.0.4.255 firehol+vpn",
+	}, ranges(blocklist.Intersection(allowlist, concat)))
+
+	r.Equal([]string{
+		"10.0.0.0 - 10.0.0.255 firehol",
+		"10.0.2.0 - 10.0.2.255 firehol",
+		"10.0.5.0 - 10.0.255.255 firehol",
+		"34.64.0.0 - 34.127.255.255 pushing_inertia",
+	}, ranges(blocklist.Difference(allowlist)))
+
+	r.Empty(ranges(allowlist.Difference(blocklist)))
+}
+
+func TestFindExact(t *testing.T) {
+	r := require.New(t)
+
+	tree := NewIntervalTree[int]()
+	ranges := randomRanges(10000, 1)
+
+	for i, in := range ranges {
+		tree.Upsert(in, i)
+	}
+	// Upsert existing keys again, only the payload should change.
+	for i, in := range ranges[:100] {
+		tree.Upsert(in, -i)
+	}
+
+	for i, in := range ranges {
+		res, err := tree.FindExact(in)
+		r.NoError(err)
+		r.Equal(in.low, res.Interval.low)
+		r.Equal(in.high, res.Interval.high)
+		if i < 100 {
+			r.Equal(-i, res.Payload)
+		} else {
+			r.Equal(i, res.Payload)
+		}
+	}
+	r.Len(tree.InOrder(), len(ranges))
+
+	for _, in := range ranges[:5000] {
+		tree.Delete(in)
+		_, err := tree.FindExact(in)
+		r.Error(err)
+	}
+	r.Len(tree.InOrder(), len(ranges)-5000)
+
+	missing := newInterval(ranges[0].low, ranges[0].high+1)
+	_, err := tree.FindExact(missing)
+	r.Error(err)
+}
+
+func TestFindNarrowestOverlapping(t *testing.T) {
+	r := require.New(t)
+
+	tree := NewIntervalTree[string]()
+	for _, c := range []struct{ cidr, payload string }{
+		{"0.0.0.0/0", "all"},
+		{"34.64.0.0/10", "blocklist"},
+		{"34.64.160.0/19", "GCP"},
+		{"34.64.161.0/24", "subnet"},
+		{"34.128.0.0/10", "other"},
+	} {
+		start, end, err := iputil.CIDRToIPRange(c.cidr)
+		r.NoError(err)
+		in, err := NewInterval(start, end)
+		r.NoError(err)
+		tree.Upsert(in, c.payload)
+	}
+
+	for _, c := range []struct{ ip, narrowest, widest string }{
+		{"34.64.161.255", "subnet", "all"},
+		{"34.64.160.1", "GCP", "all"},
+		{"34.100.0.1", "blocklist", "all"},
+		{"34.128.0.0", "other", "all"},
+		{"1.1.1.1", "all", "all"},
+	} {
+		key, err := NewInterval(c.ip, c.ip)
+		r.NoError(err)
+
+		narrowest, err := tree.FindNarrowestOverlapping(key)
+		r.NoError(err)
+		r.Equal(c.narrowest, narrowest.Payload, c.ip)
+
+		widest, err := tree.FindWidestOverlapping(key)
+		r.NoError(err)
+		r.Equal(c.widest, widest.Payload, c.ip)
+
+		all, err := tree.FindAllOverlapping(key)
+		r.NoError(err)
+		r.Equal(narrowest, Narrowest(all))
+		r.Equal(widest, Widest(all))
+	}
+
+	// Compare against a brute force search on random ranges.
+	tree = NewIntervalTree[string]()
+	for _, in := range randomRanges(1000, 5) {
+		tree.Upsert(in, in.String())
+	}
+
+	rnd := rand.New(rand.NewSource(5))
+	for i := 0; i < 1000; i++ {
+		ip := rnd.Uint32()
+		key := newInterval(ip, ip)
+
+		all, err := tree.FindAllOverlapping(key)
+		if err != nil {
+			_, err = tree.FindNarrowestOverlapping(key)
+			r.Error(err)
+			continue
+		}
+
+		narrowest, err := tree.FindNarrowestOverlapping(key)
+		r.NoError(err)
+		r.Equal(Narrowest(all), narrowest)
+
+		widest, err := tree.FindWidestOverlapping(key)
+		r.NoError(err)
+		r.Equal(Widest(all), widest)
+	}
+
+	_, err := NewIntervalTree[string]().FindNarrowestOverlapping(newInterval(1, 1))
+	r.Error(err)
+}
+
+// randomRanges returns n unique random IP ranges.
+func randomRanges(n int, seed int64) []Interval {
+	rnd := rand.New(rand.NewSource(seed))
+	seen := make(map[[2]uint32]bool, n)
+	ranges := make([]Interval, 0, n)
+
+	for len(ranges) < n {
+		low := rnd.Uint32()
+		high := low + uint32(rnd.Intn(1<<16))
+		if high < low {
+			continue
+		}
+		if seen[[2]uint32{low, high}] {
+			continue
+		}
+		seen[[2]uint32{low, high}] = true
+		ranges = append(ranges, newInterval(low, high))
+	}
+
+	return ranges
+}
+
+func BenchmarkUpsert1M(b *testing.B) {
+	ranges := randomRanges(1_000_000, 1)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		tree := NewIntervalTree[any]()
+		for _, in := range ranges {
+			tree.Upsert(in, nil)
+		}
+	}
+}
+
+func BenchmarkFindExact(b *testing.B) {
+	ranges := randomRanges(1_000_000, 1)
+	tree := NewIntervalTree[any]()
+	for _, in := range ranges {
+		tree.Upsert(in, nil)
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		tree.FindExact(ranges[i%len(ranges)])
+	}
+}
+
+func TestBuild(t *testing.T) {
+	r := require.New(t)
+
+	for _, n := range []int{0, 1, 2, 3, 7, 8, 9, 1000, 4097} {
+		ranges := randomRanges(n, int64(n))
+
+		entries := make([]Entry[int], 0, n+1)
+		upserted := NewIntervalTree[int]()
+		for i, in := range ranges {
+			entries = append(entries, Entry[int]{Interval: in, Payload: i})
+			upserted.Upsert(in, i)
+		}
+		if n > 0 {
+			// Duplicates are replaced, like with Upsert.
+			entries = append(entries, Entry[int]{Interval: ranges[0], Payload: -1})
+			upserted.Upsert(ranges[0], -1)
+		}
+
+		tree := Build(entries)
+		checkInvariants(r, tree)
+		r.Equal(upserted.InOrder(), tree.InOrder())
+
+		for _, in := range ranges {
+			search := newInterval(in.high, in.high)
+			want, _ := upserted.FindAllOverlapping(search)
+			got, _ := tree.FindAllOverlapping(search)
+			r.Equal(want, got)
+		}
+
+		// The built tree must stay valid when modified.
+		for _, in := range randomRanges(100, -1) {
+			tree.Upsert(in, 0)
+		}
+		for _, in := range ranges[:n/2] {
+			tree.Delete(in)
+		}
+		checkInvariants(r, tree)
+	}
+
+	_, err := BuildFromSorted([]Entry[int]{
+		{Interval: newInterval(10, 20)},
+		{Interval: newInterval(5, 20)},
+	})
+	r.Error(err)
+}
+
+// checkInvariants checks that the tree is a valid red-black tree with correct
+// max values.
+func checkInvariants[T any](r *require.Assertions, tree *Tree[T]) {
+	r.Equal(black, tree.root.color)
+
+	var walk func(z *node[T]) (blackHeight int, max uint32)
+	walk = func(z *node[T]) (int, uint32) {
+		if z == tree.sentinel {
+			return 0, 0
+		}
+
+		if z.color == red {
+			r.Equal(black, z.left.color)
+			r.Equal(black, z.right.color)
+		}
+		if z.left != tree.sentinel {
+			r.True(z.left.key.less(z.key))
+			r.Equal(z, z.left.parent)
+		}
+		if z.right != tree.sentinel {
+			r.True(z.key.less(z.right.key))
+			r.Equal(z, z.right.parent)
+		}
+
+		lh, lmax := walk(z.left)
+		rh, rmax := walk(z.right)
+		r.Equal(lh, rh)
+
+		max := z.key.high
+		if lmax > max {
+			max = lmax
+		}
+		if rmax > max {
+			max = rmax
+		}
+		r.Equal(max, z.max)
+
+		if z.color == black {
+			lh++
+		}
+		return lh, max
+	}
+
+	walk(tree.root)
+}
+
+func BenchmarkBuild1M(b *testing.B) {
+	ranges := randomRanges(1_000_000, 1)
+	entries := make([]Entry[any], 0, len(ranges))
+	for _, in := range ranges {
+		entries = append(entries, Entry[any]{Interval: in})
+	}
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Build(entries)
+	}
+}
+
+func TestFlatIndex(t *testing.T) {
+	r := require.New(t)
+
+	entries := make([]Entry[int], 0, 2000)
+	for i, in := range randomRanges(2000, 7) {
+		entries = append(entries, Entry[int]{Interval: in, Payload: i})
+	}
+	// Add some nested ranges.
+	entries = append(entries,
+		Entry[int]{Interval: newInterval(0, 1<<31), Payload: -1},
+		Entry[int]{Interval: newInterval(1<<30, 1<<30+1<<20), Payload: -2},
+	)
+
+	tree := Build(entries)
+	idx := NewFlatIndex(entries)
+	r.Equal(len(entries), idx.Len())
+
+	rnd := rand.New(rand.NewSource(7))
+	for i := 0; i < 10000; i++ {
+		low := rnd.Uint32()
+		high := low
+		if i%2 == 0 {
+			high += uint32(rnd.Intn(1 << 20))
+			if high < low {
+				high = low
+			}
+		}
+		search := newInterval(low, high)
+
+		want, wantErr := tree.FindAllOverlapping(search)
+		got, gotErr := idx.FindAllOverlapping(search)
+		r.Equal(wantErr, gotErr)
+		r.Equal(want, got)
+
+		first, err := idx.FindFirstOverlapping(search)
+		if wantErr != nil {
+			r.Error(err)
+		} else {
+			r.NoError(err)
+			r.Equal(want[0], first)
+		}
+	}
+
+	_, err := NewFlatIndex[int](nil).FindFirstOverlapping(newInterval(1, 1))
+	r.Error(err)
+}
+
+func TestFlatten(t *testing.T) {
+	r := require.New(t)
+
+	entries := []Entry[string]{
+		{Interval: newInterval(10, 19), Payload: "a"},
+		{Interval: newInterval(20, 29), Payload: "a"},
+		{Interval: newInterval(15, 24), Payload: "b"},
+		{Interval: newInterval(15, 24), Payload: "c"},
+		{Interval: newInterval(0, 100), Payload: "a"},
+		{Interval: newInterval(200, 200), Payload: "d"},
+	}
+
+	segments := Flatten(entries)
+	r.Len(segments, 4)
+
+	r.Equal(newInterval(0, 14), segments[0].Interval)
+	r.Equal([]string{"a"}, segments[0].Payloads)
+	r.Equal(newInterval(15, 24), segments[1].Interval)
+	r.Equal([]string{"a", "b", "c"}, segments[1].Payloads)
+	r.Equal(newInterval(25, 100), segments[2].Interval)
+	r.Equal([]string{"a"}, segments[2].Payloads)
+	r.Equal(newInterval(200, 200), segments[3].Interval)
+	r.Equal([]string{"d"}, segments[3].Payloads)
+
+	s, found := segments.Find(20)
+	r.True(found)
+	r.Equal([]string{"a", "b", "c"}, s.Payloads)
+
+	_, found = segments.Find(150)
+	r.False(found)
+	_, found = segments.Find(201)
+	r.False(found)
+
+	// Segments of a tree carry the payloads of all intervals covering them,
+	// and must agree with an overlap search for every IP.
+	tree := NewIntervalTree[int]()
+	for i, in := range randomRanges(500, 11) {
+		tree.Upsert(in, i)
+	}
+	tree.Upsert(newInterval(0, 1<<31), -1)
+
+	treeSegments := tree.Flatten()
+	for i, s := range treeSegments {
+		if i > 0 {
+			r.Less(treeSegments[i-1].Interval.high, s.Interval.low)
+		}
+
+		for _, ip := range []uint32{s.Interval.low, s.Interval.high} {
+			results, err := tree.FindAllOverlapping(newInterval(ip, ip))
+			r.NoError(err)
+
+			var want []int
+			for _, res := range results {
+				want = append(want, res.Payload)
+			}
+			r.Equal(want, s.Payloads)
+
+			found, ok := treeSegments.Find(ip)
+			r.True(ok)
+			r.Equal(s, found)
+		}
+	}
+
+	// Only the Flatten function merges adjacent segments with equal payloads.
+	adjacent := entries[:2]
+	r.Len(Flatten(adjacent), 1)
+	r.Equal(Segments[string]{
+		{Interval: newInterval(10, 19), Payloads: []string{"a"}},
+		{Interval: newInterval(20, 29), Payloads: []string{"a"}},
+	}, Build(adjacent).Flatten())
+	r.Equal(Build(adjacent).Flatten(), NewFlatIndex(adjacent).Flatten())
+}
+
+// BenchmarkLookup checks 588k IPs against 33k ranges, similar to the
+// workload described in the README.
+func BenchmarkLookup(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+
+	entries := make([]Entry[string], 0, 33_000)
+	for _, in := range randomRanges(33_000, 1) {
+		entries = append(entries, Entry[string]{Interval: in, Payload: "vendor"})
+	}
+
+	ips := make([]Interval, 588_000)
+	for i := range ips {
+		ip := rnd.Uint32()
+		if i%2 == 0 {
+			// Make sure about half of the IPs are matches.
+			ip = entries[rnd.Intn(len(entries))].Interval.low
+		}
+		ips[i] = newInterval(ip, ip)
+	}
+
+	ipns := make([]uint32, len(ips))
+	for i, ip := range ips {
+		ipns[i] = ip.low
+	}
+
+	for _, bm := range []struct {
+		name  string
+		index Index[string]
+	}{
+		{"tree", Build(entries)},
+		{"flat", NewFlatIndex(entries)},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, ip := range ips {
+					bm.index.FindFirstOverlapping(ip)
+				}
+			}
+		})
+
+		b.Run(bm.name+"-batch", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				bm.index.LookupBatch(ipns)
+			}
+		})
+	}
+}
+
+func TestLookupBatch(t *testing.T) {
+	r := require.New(t)
+
+	entries := make([]Entry[int], 0, 2001)
+	for i, in := range randomRanges(2000, 11) {
+		entries = append(entries, Entry[int]{Interval: in, Payload: i})
+	}
+	entries = append(entries, Entry[int]{Interval: newInterval(1<<30, 1<<31), Payload: -1})
+
+	rnd := rand.New(rand.NewSource(11))
+	ips := make([]uint32, 20000)
+	for i := range ips {
+		ips[i] = rnd.Uint32()
+		if i%3 == 0 {
+			ips[i] = entries[rnd.Intn(len(entries))].Interval.high
+		}
+	}
+	// Duplicates.
+	ips = append(ips, ips[:100]...)
+
+	for _, index := range []Index[int]{Build(entries), NewFlatIndex(entries)} {
+		results := index.LookupBatch(ips)
+		r.Len(results, len(ips))
+
+		for i, ip := range ips {
+			want, _ := index.FindAllOverlapping(newInterval(ip, ip))
+			r.Equal(want, results[i])
+		}
+	}
+
+	r.Len(NewIntervalTree[int]().LookupBatch(ips), len(ips))
+}
+
+func TestIterate(t *testing.T) {
+	r := require.New(t)
+
+	tree := NewIntervalTree[int]()
+	_, err := tree.Max()
+	r.Error(err)
+	r.Equal(0, tree.Len())
+	r.Equal(0, tree.StabCount(newInterval(1, 1)))
+
+	ranges := randomRanges(1000, 3)
+	for i, in := range ranges {
+		tree.Upsert(in, i)
+	}
+	tree.Upsert(ranges[0], -1)
+	r.Equal(len(ranges), tree.Len())
+
+	all := tree.InOrder()
+
+	min, err := tree.Min()
+	r.NoError(err)
+	r.Equal(all[0], min)
+	max, err := tree.Max()
+	r.NoError(err)
+	r.Equal(all[len(all)-1], max)
+
+	for i := range all {
+		pred, err := tree.Predecessor(all[i].Interval)
+		if i == 0 {
+			r.Error(err)
+		} else {
+			r.NoError(err)
+			r.Equal(all[i-1], pred)
+		}
+	}
+
+	var visited []Result[int]
+	tree.Ascend(func(re Result[int]) bool {
+		visited = append(visited, re)
+		return true
+	})
+	r.Equal(all, visited)
+
+	// Stop early.
+	visited = nil
+	tree.Ascend(func(re Result[int]) bool {
+		visited = append(visited, re)
+		return len(visited) < 10
+	})
+	r.Equal(all[:10], visited)
+
+	from, to := all[100].Interval.low, all[199].Interval.low
+	visited = nil
+	tree.AscendRange(from, to, func(re Result[int]) bool {
+		visited = append(visited, re)
+		return true
+	})
+	r.Equal(all[100:200], visited)
+
+	for _, in := range ranges[:100] {
+		search := newInterval(in.high, in.high)
+		overlapping, _ := tree.FindAllOverlapping(search)
+		r.Equal(len(overlapping), tree.StabCount(search))
+	}
+
+	for _, in := range ranges[:500] {
+		tree.Delete(in)
+	}
+	r.Equal(500, tree.Len())
+
+	entries := make([]Entry[int], 0, 500)
+	for i, in := range ranges[500:] {
+		entries = append(entries, Entry[int]{Interval: in, Payload: i})
+	}
+	r.Equal(500, Build(entries).Len())
+}
+
+func TestLive(t *testing.T) {
+	r := require.New(t)
+
+	ranges := randomRanges(1000, 5)
+	version := func(v int) *FlatIndex[int] {
+		entries := make([]Entry[int], 0, len(ranges))
+		for _, in := range ranges {
+			entries = append(entries, Entry[int]{Interval: in, Payload: v})
+		}
+		return NewFlatIndex(entries)
+	}
+
+	live := NewLive[int](nil)
+	r.Equal(0, live.Len())
+
+	live.Store(version(1))
+	r.Equal(len(ranges), live.Len())
+
+	// Readers must always see a complete version, i.e. all intervals with
+	// the same payload, while writers keep publishing new ones.
+	done := make(chan struct{})
+	errs := make(chan error, 4)
+	var wg sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				snapshot := live.Load()
+				first, _ := snapshot.FindFirstOverlapping(ranges[0])
+				want := first.Payload
+				for _, in := range ranges[:50] {
+					re, err := snapshot.FindFirstOverlapping(in)
+					if err != nil || re.Payload != want {
+						errs <- fmt.Errorf("inconsistent snapshot: %v %d != %d", err, re.Payload, want)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for v := 2; v < 20; v++ {
+		live.Store(version(v))
+	}
+	live.Update(func(tree *Tree[int]) {
+		for _, in := range ranges {
+			tree.Upsert(in, 100)
+		}
+		tree.Delete(ranges[1])
+	})
+
+	close(done)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		r.NoError(err)
+	}
+
+	r.Equal(len(ranges)-1, live.Len())
+	re, err := live.FindFirstOverlapping(ranges[0])
+	r.NoError(err)
+	r.Equal(100, re.Payload)
+}
+
+func BenchmarkParallelLookup(b *testing.B) {
+	entries := make([]Entry[string], 0, 33_000)
+	for _, in := range randomRanges(33_000, 1) {
+		entries = append(entries, Entry[string]{Interval: in, Payload: "vendor"})
+	}
+
+	for _, bm := range []struct {
+		name  string
+		index Index[string]
+	}{
+		{"tree", Build(entries)},
+		{"live", NewLive(NewFlatIndex(entries))},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.RunParallel(func(pb *testing.PB) {
+				rnd := rand.New(rand.NewSource(1))
+				for pb.Next() {
+					ip := entries[rnd.Intn(len(entries))].Interval.low
+					bm.index.FindFirstOverlapping(newInterval(ip, ip))
+				}
+			})
+		})
+	}
+}
+
+func TestSerialization(t *testing.T) {
+	r := require.New(t)
+
+	tree := NewIntervalTree[string]()
+	for i, in := range randomRanges(5000, 13) {
+		tree.Upsert(in, fmt.Sprintf("list-%d", i%10))
+	}
+
+	data, err := tree.MarshalBinary()
+	r.NoError(err)
+
+	read := NewIntervalTree[string]()
+	r.NoError(read.UnmarshalBinary(data))
+	r.Equal(tree.InOrder(), read.InOrder())
+	r.Equal(tree.Len(), read.Len())
+	checkInvariants(r, read)
+
+	// Trees can be written and read one after the other.
+	var buf bytes.Buffer
+	n1, err := tree.WriteTo(&buf)
+	r.NoError(err)
+	n2, err := NewIntervalTree[string]().WriteTo(&buf)
+	r.NoError(err)
+	r.Equal(int64(buf.Len()), n1+n2)
+
+	first, second := NewIntervalTree[string](), NewIntervalTree[string]()
+	_, err = first.ReadFrom(&buf)
+	r.NoError(err)
+	_, err = second.ReadFrom(&buf)
+	r.NoError(err)
+	r.Equal(tree.InOrder(), first.InOrder())
+	r.Equal(0, second.Len())
+
+	// Corrupt data is detected.
+	data[len(data)/2] ^= 0xff
+	r.Error(read.UnmarshalBinary(data))
+	r.Error(read.UnmarshalBinary(data[:len(data)-1]))
+	r.Error(read.UnmarshalBinary([]byte("not a tree")))
+
+	// Corrupt lengths fail on the missing data rather than allocating it.
+	data, err = NewIntervalTree[string]().MarshalBinary()
+	r.NoError(err)
+	binary.BigEndian.PutUint32(data[14:], math.MaxUint32)
+	r.ErrorIs(read.UnmarshalBinary(data), io.ErrUnexpectedEOF)
+
+	// Entries may share an interval, and hold payloads which can't be
+	// compared.
+	entries := []Entry[any]{
+		{Interval: newInterval(1, 10), Payload: []int{1}},
+		{Interval: newInterval(1, 10), Payload: "a"},
+		{Interval: newInterval(5, 20), Payload: "a"},
+	}
+	buf.Reset()
+	_, err = WriteEntries(&buf, entries)
+	r.NoError(err)
+	readEntries, _, err := ReadEntries[any](&buf)
+	r.NoError(err)
+	r.Equal(entries, readEntries)
+
+	// Payloads hiding a slice behind an interface aren't comparable either.
+	type wrapped struct{ V any }
+	seen := make(map[interface{}]uint32)
+	for _, p := range []any{nil, wrapped{V: []int{1}}, [1]any{[]int{1}}, wrapped{V: "a"}, nil, wrapped{V: "a"}} {
+		payloadID(seen, p, uint32(len(seen)))
+	}
+	r.Len(seen, 2)
+}
@@ -0,0 +1,99 @@
+package ipcheck
+
+import (
+	"bufio"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ASN is the autonomous system an IP belongs to.
+type ASN struct {
+	Number      int    `json:"number"`
+	Country     string `json:"country,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type asnRange struct {
+	first, last netip.Addr
+	asn         *ASN
+}
+
+// asnDB holds non-overlapping ranges of IPs sorted by their first IP.
+type asnDB struct {
+	ranges []asnRange
+}
+
+// loadASNFile loads an IP to ASN database in the TSV format published at
+// https://iptoasn.com, with one range per line:
+//
+//	range_start	range_end	AS_number	country_code	AS_description
+//
+// Ranges which aren't routed (AS number 0) are skipped.
+func loadASNFile(file string) (*asnDB, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not open ASN file: %s", file)
+	}
+	defer f.Close()
+
+	db := &asnDB{}
+	scanner := bufio.NewScanner(f)
+	var lineNumber int
+
+	for scanner.Scan() {
+		lineNumber++
+
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 5 {
+			return nil, errors.Errorf("expected 5 columns on line %d of ASN file %s, got %d", lineNumber, file, len(fields))
+		}
+
+		number, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid AS number on line %d of ASN file %s", lineNumber, file)
+		}
+		if number == 0 {
+			continue
+		}
+
+		first, err := netip.ParseAddr(fields[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid IP on line %d of ASN file %s", lineNumber, file)
+		}
+		last, err := netip.ParseAddr(fields[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid IP on line %d of ASN file %s", lineNumber, file)
+		}
+
+		db.ranges = append(db.ranges, asnRange{
+			first: first.Unmap(),
+			last:  last.Unmap(),
+			asn:   &ASN{Number: number, Country: fields[3], Description: fields[4]},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read line from ASN file: %s", file)
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool { return db.ranges[i].first.Less(db.ranges[j].first) })
+
+	return db, nil
+}
+
+// lookup returns the ASN of addr, or nil if it's unknown.
+func (db *asnDB) lookup(addr netip.Addr) *ASN {
+	addr = addr.Unmap()
+
+	// Find the last range starting at or before addr.
+	i := sort.Search(len(db.ranges), func(i int) bool { return addr.Less(db.ranges[i].first) }) - 1
+	if i < 0 || db.ranges[i].last.Less(addr) || db.ranges[i].first.Is4() != addr.Is4() {
+		return nil
+	}
+
+	return db.ranges[i].asn
+}
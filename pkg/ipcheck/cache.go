@@ -0,0 +1,305 @@
+package ipcheck
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/anrid/ipcheck/pkg/interval"
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/pkg/errors"
+)
+
+// Loading a big FireHOL file takes a while, so the checker can cache the data
+// loaded from it in a file next to it (<file>.cache). The cache file starts
+// with a header line identifying the FireHOL file it was created from:
+//
+//	ipcheck-cache 1 <size> <modification time in ns> <number of ranges>
+//
+// followed by all ranges, including ranges of the same CIDR from different
+// sets, in the format written by interval.WriteEntries, and the single IPs, all integers big-endian:
+//
+//	size  field
+//	4     length L of the sources table in bytes
+//	L     sources table, a gob encoded map[uint16]string
+//	4     number of IPs N
+//	6*N   IPs, each as: IP, source ID (uint16)
+//	4     CRC-32 (IEEE) checksum of the IP section
+//
+// The cache is only used if the size and modification time of the FireHOL
+// file still match the header.
+
+const fireHOLCacheVersion = 1
+
+// fireHOLData is everything loaded from a FireHOL file.
+type fireHOLData struct {
+	// ranges are sorted by interval, ranges of the same CIDR in file order.
+	ranges    []interval.Entry[string]
+	numRanges int
+	ips       map[uint32]uint16
+	sources   map[uint16]string
+}
+
+// loadFireHOL loads all CIDRs and IPs from a FireHOL file created with
+// firehol.Download. The sources are the full IP set headers, see
+// splitFireHOLHeader.
+func loadFireHOL(file string) (*fireHOLData, error) {
+	fh := &fireHOLData{
+		ips:     make(map[uint32]uint16),
+		sources: make(map[uint16]string),
+	}
+
+	var src string
+	var srcID uint16
+
+	err := readFireHOLFile(file, func(header string) {
+		src = header
+		srcID++
+		fh.sources[srcID] = src
+	}, func(entry string) error {
+		if isIPv6(entry) {
+			return nil
+		}
+
+		if strings.ContainsRune(entry, '/') {
+			// CIDR
+			r, err := cidrInterval(entry)
+			if err != nil {
+				return errors.Wrapf(err, "could not create interval for CIDR %s", entry)
+			}
+
+			fh.ranges = append(fh.ranges, interval.Entry[string]{Interval: r, Payload: src})
+
+			fh.numRanges++
+		} else {
+			// IP
+			c, err := iputil.ParseCIDR(entry)
+			if err != nil {
+				return err
+			}
+			fh.ips[c.IP] = srcID
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(fh.ranges, func(i, j int) bool {
+		a, b := fh.ranges[i].Interval, fh.ranges[j].Interval
+		return a.Start() < b.Start() || a.Start() == b.Start() && a.Stop() < b.Stop()
+	})
+
+	return fh, nil
+}
+
+// loadFireHOLSource loads a FireHOL file for Check, using its cache if
+// enabled.
+func loadFireHOLSource(file string, p CheckAgainstIPRangesParams) (*fireHOLData, error) {
+	if p.VerboseOutput {
+		fmt.Printf("Loading FireHOL data from %s (this takes a while) ..\n", file)
+	}
+
+	if p.CacheFireHOL {
+		fh, err := readFireHOLCache(file)
+		if err != nil && p.VerboseOutput {
+			fmt.Printf("Could not use cached FireHOL data: %s\n", err)
+		}
+		if fh != nil {
+			if p.VerboseOutput {
+				fmt.Printf("Using cached FireHOL data from %s\n", fireHOLCacheFile(file))
+			}
+			return fh, nil
+		}
+	}
+
+	fh, err := loadFireHOL(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.CacheFireHOL {
+		// The data is loaded, so failing to cache it only costs time next run.
+		err = writeFireHOLCache(file, fh)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not cache FireHOL data: %s\n", err)
+		} else if p.VerboseOutput {
+			fmt.Printf("Cached FireHOL data in %s\n", fireHOLCacheFile(file))
+		}
+	}
+
+	return fh, nil
+}
+
+func fireHOLCacheFile(file string) string {
+	return file + ".cache"
+}
+
+func fireHOLCacheHeader(file string) (string, error) {
+	s, err := os.Stat(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "could not stat FireHOL DB file: %s", file)
+	}
+
+	return fmt.Sprintf("ipcheck-cache %d %d %d", fireHOLCacheVersion, s.Size(), s.ModTime().UnixNano()), nil
+}
+
+// writeFireHOLCache writes the data loaded from the FireHOL file to its cache
+// file. The data is written to a temporary file first and then renamed, so
+// readers never see a half written cache file.
+func writeFireHOLCache(file string, fh *fireHOLData) error {
+	header, err := fireHOLCacheHeader(file)
+	if err != nil {
+		return err
+	}
+
+	cacheFile := fireHOLCacheFile(file)
+
+	f, err := os.CreateTemp(filepath.Dir(cacheFile), filepath.Base(cacheFile)+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "could not create FireHOL cache file: %s", cacheFile)
+	}
+	defer os.Remove(f.Name())
+
+	err = writeFireHOLCacheData(f, header, fh)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "could not write FireHOL cache file: %s", cacheFile)
+	}
+
+	err = os.Rename(f.Name(), cacheFile)
+	if err != nil {
+		return errors.Wrapf(err, "could not write FireHOL cache file: %s", cacheFile)
+	}
+
+	return nil
+}
+
+func writeFireHOLCacheData(out io.Writer, header string, fh *fireHOLData) error {
+	w := bufio.NewWriter(out)
+	fmt.Fprintf(w, "%s %d\n", header, fh.numRanges)
+
+	_, err := interval.WriteEntries(w, fh.ranges)
+	if err != nil {
+		return err
+	}
+
+	h := crc32.NewIEEE()
+	hw := io.MultiWriter(w, h)
+
+	var sources bytes.Buffer
+	err = gob.NewEncoder(&sources).Encode(fh.sources)
+	if err != nil {
+		return err
+	}
+	binary.Write(hw, binary.BigEndian, uint32(sources.Len()))
+	hw.Write(sources.Bytes())
+
+	binary.Write(hw, binary.BigEndian, uint32(len(fh.ips)))
+	var buf [6]byte
+	for ip, srcID := range fh.ips {
+		binary.BigEndian.PutUint32(buf[:4], ip)
+		binary.BigEndian.PutUint16(buf[4:], srcID)
+		hw.Write(buf[:])
+	}
+	binary.Write(w, binary.BigEndian, h.Sum32())
+
+	return w.Flush()
+}
+
+// readFireHOLCache reads the cached data for the FireHOL file. Returns nil if
+// there is no cache file, or an error if it's out of date or can't be read.
+func readFireHOLCache(file string) (*fireHOLData, error) {
+	header, err := fireHOLCacheHeader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cacheFile := fireHOLCacheFile(file)
+
+	f, err := os.Open(cacheFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "could not open FireHOL cache file: %s", cacheFile)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	fh := new(fireHOLData)
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read FireHOL cache file: %s", cacheFile)
+	}
+	if !strings.HasPrefix(line, header+" ") {
+		return nil, errors.Errorf("FireHOL cache file is out of date: %s", cacheFile)
+	}
+	if _, err := fmt.Sscanf(line[len(header):], " %d\n", &fh.numRanges); err != nil {
+		return nil, errors.Wrapf(err, "invalid FireHOL cache file header: %s", cacheFile)
+	}
+
+	fh.ranges, _, err = interval.ReadEntries[string](r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read ranges from FireHOL cache file: %s", cacheFile)
+	}
+
+	h := crc32.NewIEEE()
+	hr := io.TeeReader(r, h)
+
+	var sourcesLen, numIPs uint32
+	err = binary.Read(hr, binary.BigEndian, &sourcesLen)
+	if err == nil {
+		var sources []byte
+		sources, err = readCacheBytes(hr, int64(sourcesLen))
+		if err == nil {
+			err = gob.NewDecoder(bytes.NewReader(sources)).Decode(&fh.sources)
+		}
+	}
+	if err == nil {
+		err = binary.Read(hr, binary.BigEndian, &numIPs)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read sources from FireHOL cache file: %s", cacheFile)
+	}
+
+	ips, err := readCacheBytes(hr, 6*int64(numIPs))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not read IPs from FireHOL cache file: %s", cacheFile)
+	}
+
+	var checksum uint32
+	if err := binary.Read(r, binary.BigEndian, &checksum); err != nil || checksum != h.Sum32() {
+		return nil, errors.Errorf("FireHOL cache file is corrupt: %s", cacheFile)
+	}
+
+	fh.ips = make(map[uint32]uint16, len(ips)/6)
+	for i := 0; i < len(ips); i += 6 {
+		fh.ips[binary.BigEndian.Uint32(ips[i:])] = binary.BigEndian.Uint16(ips[i+4:])
+	}
+
+	return fh, nil
+}
+
+// readCacheBytes reads n bytes from r as they come rather than allocating them
+// up front, as n is read from the cache file before its checksum is verified.
+func readCacheBytes(r io.Reader, n int64) ([]byte, error) {
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r, n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
@@ -0,0 +1,75 @@
+package ipcheck
+
+import (
+	"sort"
+
+	"github.com/anrid/ipcheck/pkg/iputil"
+)
+
+// ListDiff holds the changes to a single list between two sets of ranges.
+type ListDiff struct {
+	List string `json:"list"`
+	// Status is "added" or "removed" if the list only exists in the new or
+	// old ranges respectively, or "changed".
+	Status string `json:"status"`
+	// Added and Removed are the IPs added to and removed from the list, as
+	// disjoint ranges in ascending order.
+	Added      []iputil.IPRange `json:"added"`
+	Removed    []iputil.IPRange `json:"removed"`
+	AddedIPs   uint64           `json:"added_ips"`
+	RemovedIPs uint64           `json:"removed_ips"`
+}
+
+// Diff compares two sets of ranges list by list (e.g. two downloads of the
+// same sources), returning all lists with any IPs added or removed, sorted by
+// name.
+func Diff(oldRanges, newRanges []Range) []ListDiff {
+	byList := func(ranges []Range) map[string][]iputil.IPRange {
+		res := make(map[string][]iputil.IPRange)
+		for _, r := range ranges {
+			res[r.List] = append(res[r.List], iputil.IPRange{Start: r.Start, End: r.End})
+		}
+		return res
+	}
+	oldLists, newLists := byList(oldRanges), byList(newRanges)
+
+	names := make([]string, 0, len(oldLists)+len(newLists))
+	for name := range oldLists {
+		names = append(names, name)
+	}
+	for name := range newLists {
+		if _, found := oldLists[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var diffs []ListDiff
+
+	for _, name := range names {
+		o, inOld := oldLists[name]
+		n, inNew := newLists[name]
+
+		d := ListDiff{
+			List:    name,
+			Status:  "changed",
+			Added:   iputil.SubtractRanges(n, o),
+			Removed: iputil.SubtractRanges(o, n),
+		}
+		if len(d.Added) == 0 && len(d.Removed) == 0 {
+			continue
+		}
+
+		switch {
+		case !inOld:
+			d.Status = "added"
+		case !inNew:
+			d.Status = "removed"
+		}
+		d.AddedIPs, d.RemovedIPs = iputil.CountIPs(d.Added), iputil.CountIPs(d.Removed)
+
+		diffs = append(diffs, d)
+	}
+
+	return diffs
+}
@@ -7,11 +7,16 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/anrid/ipcheck/pkg/interval"
 	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/anrid/ipcheck/pkg/radix"
 	"github.com/pkg/errors"
 )
 
@@ -20,118 +25,401 @@ const (
 )
 
 type CheckAgainstIPRangesParams struct {
-	InputFileORURL              string
-	IPRangesCSVFileOrURL        string
-	FireHOLFile                 string
+	InputFileORURL       string
+	IPRangesCSVFileOrURL string
+	FireHOLFile          string
+	// Sources are checked in addition to the IP ranges CSV and FireHOL file.
+	Sources []Source
+	// Filter only reports matches from the selected sources.
+	Filter MatchFilter
+	// Scoring configures the risk score of each matched IP, which is based
+	// on all lists it's found in regardless of Match.
+	Scoring                     Scoring
 	ShowAdditionalBlocklistInfo bool
 	VerboseOutput               bool
 	ToCSVFile                   string
+	// Batch reads all IPs from the input file before looking them up in
+	// one go, which is faster for large files but means no matches are
+	// reported until the whole file has been read.
+	Batch bool
+	// CacheFireHOL caches the data loaded from the FireHOL file next to it
+	// and reuses it for as long as the FireHOL file doesn't change.
+	CacheFireHOL bool
+	// Index selects the structure used to look up IP ranges: "tree" (the
+	// default), "flat" (see interval.FlatIndex) or "radix" (see radix.Index).
+	Index string
+	// Match selects which ranges are reported when an IP is covered by more
+	// than one: "first" (the default, the first range found), "narrowest" (the
+	// most specific range, like a longest prefix match), "widest", "priority"
+	// (the narrowest range from the highest ranked sources, see Source) or
+	// "all" (ranked the same way).
+	Match string
+	// Unique reports each matched IP only once, with the number of times
+	// it was found, after the whole input has been read.
+	Unique bool
+	// Report prints aggregate statistics of the scan after the summary,
+	// and ReportFile writes them to a JSON file, see Report.
+	Report     bool
+	ReportFile string
+	// ReportTopN is the number of most frequently matched IPs and subnets
+	// to include in the report (defaults to 10).
+	ReportTopN int
+	// Allow lists patterns of lists (vendors or FireHOL IP sets) whose IPs
+	// are never reported, e.g. an allowlist of office IPs (see path.Match
+	// for the pattern syntax).
+	Allow []string
 }
 
+// MatchModes lists all supported values of CheckAgainstIPRangesParams.Match.
+var MatchModes = []string{"first", "narrowest", "widest", "priority", "all"}
+
+// Result summarizes a completed check.
+type Result struct {
+	// Matches is the number of IPs found in any range or list, counting
+	// every time an IP occurs in the input.
+	Matches       int
+	UniqueMatches int
+	// IPsChecked is the number of IPs found in the input.
+	IPsChecked       int
+	UniqueIPsChecked int
+	Dupes            int
+	// Allowed is the number of IPs skipped because they're in an allowed
+	// list.
+	Allowed int
+}
+
+// MatchRatio returns the share of IPs checked which matched, from 0 to 1.
+func (r *Result) MatchRatio() float64 {
+	if r.IPsChecked == 0 {
+		return 0
+	}
+	return float64(r.Matches) / float64(r.IPsChecked)
+}
+
+// CheckAgainstIPRanges checks all IPs found in the input file against all
+// ranges and lists, returning the number of matches. See Check.
 func CheckAgainstIPRanges(p CheckAgainstIPRangesParams) (numMatchedIPsFound int, err error) {
-	ipRanges := interval.NewIntervalTree()
-	ipNumbers := make(map[uint32]uint16)
-	ipNumberSources := make(map[uint16]string)
-	var numRanges int
+	res, err := Check(p)
+	if err != nil {
+		return 0, err
+	}
+	return res.Matches, nil
+}
 
-	if p.VerboseOutput {
-		fmt.Printf("Reading IP ranges from %s ..\n", p.IPRangesCSVFileOrURL)
+// Check checks all IPs found in the input file against all ranges and lists,
+// printing each match as it's found (unless writing to a CSV file).
+func Check(p CheckAgainstIPRangesParams) (*Result, error) {
+	var numMatchedIPsFound int
+	var err error
+
+	switch p.Match {
+	case "":
+		p.Match = "first"
+	case "first", "narrowest", "widest", "priority", "all":
+	default:
+		return nil, errors.Errorf("unknown match mode %q (supported: %s)", p.Match, strings.Join(MatchModes, ", "))
 	}
-	readCSVFileOrURL(p.IPRangesCSVFileOrURL, func(recordNumber int, record []string) error {
-		if recordNumber == 1 {
-			// Skip headers.
-			return nil
-		}
 
-		cidr := record[0]
-		vendor := record[3]
-		start, end, err := iputil.CIDRToIPRange(cidr)
+	sources, err := legacySources(p.IPRangesCSVFileOrURL, p.FireHOLFile, p.Sources)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Filter.validate(); err != nil {
+		return nil, err
+	}
+	if err := p.Scoring.Validate(); err != nil {
+		return nil, err
+	}
+	scoring, now := p.Scoring.withDefaults(), time.Now()
+
+	// Collect all ranges first and bulk load them into the interval tree
+	// once we're done, that's a lot faster than upserting them one by one.
+	// Ranges found in several lists (e.g. the same CIDR in two FireHOL IP
+	// sets) are only added once, the payload of each range is its position
+	// in rangeLists, which holds the IDs of all lists it belongs to. Ranges
+	// and IPs of allowed lists are looked up separately, IPs found in them
+	// are skipped.
+	var entries, allowedEntries []interval.Entry[int32]
+	var rangeLists [][]int32
+	rangeIDs := make(map[[2]uint32]int32)
+	// Single IPs from FireHOL files are kept in a hash map instead.
+	ipNumbers := make(map[uint32]int32)
+	var lists []checkList
+	// The number of ranges and single IPs of lists selected by the filter.
+	var numRanges, numIPs int
+
+	addList := func(src *Source, updated *time.Time, name, info string) (int32, error) {
+		allowed, err := matchesAny(p.Allow, name)
 		if err != nil {
-			return err
+			return 0, err
 		}
+		lists = append(lists, checkList{name: name, info: info, source: src, updated: updated, allowed: allowed, selected: p.Filter.selects(*src)})
+		return int32(len(lists) - 1), nil
+	}
 
-		r, err := interval.NewInterval(start, end)
-		if err != nil {
-			return err
+	// addEntry adds a range of a list. Ranges of lists not selected by the
+	// filter are added as well, they count towards the score and report.
+	addEntry := func(in interval.Interval, id int32) {
+		switch {
+		case lists[id].allowed:
+			allowedEntries = append(allowedEntries, interval.Entry[int32]{Interval: in, Payload: id})
+		default:
+			key := [2]uint32{in.Start(), in.Stop()}
+			rangeID, found := rangeIDs[key]
+			if !found {
+				rangeID = int32(len(rangeLists))
+				rangeIDs[key] = rangeID
+				rangeLists = append(rangeLists, nil)
+				entries = append(entries, interval.Entry[int32]{Interval: in, Payload: rangeID})
+			}
+			for _, listID := range rangeLists[rangeID] {
+				if listID == id {
+					return
+				}
+			}
+			rangeLists[rangeID] = append(rangeLists[rangeID], id)
+			if lists[id].selected {
+				numRanges++
+			}
 		}
+	}
 
-		ipRanges.Upsert(r, vendor)
+	for i := range sources {
+		src := &sources[i]
 
-		numRanges++
+		if p.VerboseOutput {
+			fmt.Printf("Loading source %s (%s) from %s ..\n", src.Name, src.Type, src.Path)
+		}
 
-		return nil
-	})
+		// FireHOL data is imported from here: https://github.com/firehol/blocklist-ipsets
+		// If you don't know, FireHOL is "an iptables stateful packet filtering firewall for humans!".
+		// Learn more at https://github.com/firehol/firehol.
+		if src.Type == SourceFireHOL {
+			fh, err := loadFireHOLSource(src.Path, p)
+			if err != nil {
+				return nil, err
+			}
 
-	if p.VerboseOutput {
-		fmt.Printf("Loaded %d IP ranges into interval tree\n", numRanges)
-		fmt.Printf("Loaded %d IPs into hash map\n", len(ipNumbers))
-	}
+			// List IDs by payload and source ID. IP sets without a date in
+			// their header are as old as the file.
+			fileUpdated := modTime(src.Path)
+			ids := make(map[string]int32, len(fh.sources))
+			srcIDs := make(map[uint16]int32, len(fh.sources))
+			for srcID := uint16(1); int(srcID) <= len(fh.sources); srcID++ {
+				name, info, updated := splitFireHOLHeader(fh.sources[srcID])
+				if !p.ShowAdditionalBlocklistInfo {
+					info = ""
+				}
+				if updated == nil {
+					updated = fileUpdated
+				}
+				id, err := addList(src, updated, name, info)
+				if err != nil {
+					return nil, err
+				}
+				ids[fh.sources[srcID]], srcIDs[srcID] = id, id
+			}
 
-	// Check against FireHOL data imported from here: https://github.com/firehol/blocklist-ipsets
-	// If you don't know, FireHOL is "an iptables stateful packet filtering firewall for humans!".
-	// Learn more at https://github.com/firehol/firehol.
-	if p.FireHOLFile != "" {
-		if p.VerboseOutput {
-			fmt.Printf("Loading FireHOL data from %s (this takes a while) ..\n", p.FireHOLFile)
+			for _, e := range fh.ranges {
+				addEntry(e.Interval, ids[e.Payload])
+			}
+			for ip, srcID := range fh.ips {
+				id := srcIDs[srcID]
+				ipNumbers[ip] = id
+				if lists[id].selected && !lists[id].allowed {
+					numIPs++
+				}
+			}
+
+			continue
 		}
 
-		f, err := os.Open(p.FireHOLFile)
+		listIDs := make(map[string]int32)
+
+		err = readSource(*src, func(list, info string, updated *time.Time, cidr string) error {
+			if isIPv6(cidr) {
+				return nil
+			}
+
+			id, found := listIDs[list]
+			if !found {
+				id, err = addList(src, updated, list, info)
+				if err != nil {
+					return err
+				}
+				listIDs[list] = id
+			}
+
+			in, err := cidrInterval(cidr)
+			if err != nil {
+				return err
+			}
+			addEntry(in, id)
+
+			return nil
+		})
 		if err != nil {
-			return 0, errors.Wrapf(err, "could not open FireHOL DB file: %s", p.FireHOLFile)
+			return nil, err
 		}
-		defer f.Close()
-
-		scanner := bufio.NewScanner(f)
-		var src string
-		var srcID uint16
-
-		for scanner.Scan() {
-			t := scanner.Text()
-			if len(t) > 0 {
-				if t[0] == '#' {
-					src = t[2:]
-					if !p.ShowAdditionalBlocklistInfo {
-						parts := strings.Split(src, " | ")
-						src = parts[0]
-					}
-					srcID++
-					ipNumberSources[srcID] = src
-					continue
-				}
+	}
 
-				if strings.ContainsRune(t, '/') {
-					// CIDR
-					start, end, err := iputil.CIDRToIPRange(t)
-					if err != nil {
-						return 0, err
-					}
+	if p.VerboseOutput {
+		fmt.Printf("Loaded %d IP ranges\n", numRanges)
+		fmt.Printf("Loaded %d IPs into hash map\n", numIPs)
+	}
 
-					r, err := interval.NewInterval(start, end)
-					if err != nil {
-						return 0, errors.Wrapf(err, "could not create interval for CIDR %s (%s - %s)", t, start, end)
-					}
+	var allowedRanges interval.Index[int32]
+	if len(allowedEntries) > 0 {
+		allowedRanges = interval.Build(allowedEntries)
+	}
 
-					ipRanges.Upsert(r, src)
+	var ipRanges interval.Index[int32]
+	switch p.Index {
+	case "", "tree":
+		ipRanges = interval.Build(entries)
+	case "flat":
+		ipRanges = interval.NewFlatIndex(entries)
+	case "radix":
+		ipRanges = radix.NewIndex(entries)
+	default:
+		return nil, errors.Errorf("unknown index type %q (supported: tree, flat, radix)", p.Index)
+	}
 
-					numRanges++
-				} else {
-					// IP
-					ipNumbers[iputil.IP2Long(t)] = srcID
-				}
+	findIPs := regexp.MustCompile(`(^|[^\d\.])(\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3})([^\d\.]|$)`)
+	var numIPsFound, numDupes, numAllowed int
+	// All distinct IPs checked, and all matched IPs in the order they were
+	// first found.
+	uniqueIPs := iputil.NewIPSet()
+	matched := make(map[uint32]*matchedIP)
+	var matchedIPs []*matchedIP
+
+	var stats *scanStats
+	if p.Report || p.ReportFile != "" {
+		stats = newScanStats()
+	}
+
+	// checkIP reports the IP found on the given line as a match if it's
+	// within any of the ranges in results (all ranges containing it, sorted
+	// by interval) or found in the hash map of blocked or flagged IPs.
+	checkIP := func(lineNumber int, line, ip string, results []interval.Result[int32]) {
+		results = listResults(results, rangeLists)
+		ipn := iputil.IP2Long(ip)
+		id, inHashMap := ipNumbers[ipn]
+
+		if allowedRanges != nil {
+			r, _ := interval.NewInterval(ip, ip)
+			if _, err := allowedRanges.FindFirstOverlapping(r); err == nil {
+				numAllowed++
+				return
 			}
 		}
-		if p.VerboseOutput {
-			fmt.Printf("Loaded %d IP ranges into interval tree\n", numRanges)
-			fmt.Printf("Loaded %d IPs into hash map\n", len(ipNumbers))
+		if inHashMap && lists[id].allowed {
+			numAllowed++
+			return
+		}
+
+		// The score and report are based on all lists the IP is found in,
+		// whichever matches are reported below.
+		inLists := make([]*checkList, 0, len(results)+1)
+		for _, res := range results {
+			inLists = append(inLists, &lists[res.Payload])
+		}
+		if inHashMap {
+			inLists = append(inLists, &lists[id])
+		}
+		if len(inLists) == 0 {
+			return
+		}
+
+		var foundIn []listMatch
+		if stats != nil {
+			foundIn = make([]listMatch, 0, len(inLists))
+			for _, l := range inLists {
+				foundIn = append(foundIn, listMatch{list: l.name, category: l.source.Category, source: l.source.Name})
+			}
+			stats.found(foundIn)
+		}
+
+		scoreOf := func() Score {
+			scored := make([]scoredList, 0, len(inLists))
+			for _, l := range inLists {
+				scored = append(scored, l.scored())
+			}
+			return scoring.score(scored, now)
+		}
+
+		// Only matches from lists selected by the filter are reported.
+		selected := make([]interval.Result[int32], 0, len(results))
+		for _, res := range results {
+			if lists[res.Payload].selected {
+				selected = append(selected, res)
+			}
+		}
+		results = selected
+		inHashMap = inHashMap && lists[id].selected
+
+		var info string
+		// What's shown for each match.
+		var shown []string
+
+		if len(results) > 0 {
+			// Found overlapping range(s).
+			results = selectMatches(results, p.Match, lists)
+
+			infos := make([]string, 0, len(results))
+			for _, res := range results {
+				l := &lists[res.Payload]
+				infos = append(infos, fmt.Sprintf("%s | %s - %s", l, res.Interval.IPRangeMin, res.Interval.IPRangeMax))
+				shown = append(shown, fmt.Sprintf("%-5s | %s - %s", l, res.Interval.IPRangeMin, res.Interval.IPRangeMax))
+			}
+			info = strings.Join(infos, "; ")
+		} else if inHashMap {
+			// Found matching IP.
+			l := &lists[id]
+
+			info = l.String()
+			shown = []string{info}
+		} else {
+			return
+		}
+
+		m, found := matched[ipn]
+		if found {
+			numDupes++
+		} else {
+			m = &matchedIP{ip: ip, info: info, score: scoreOf()}
+			matched[ipn] = m
+			matchedIPs = append(matchedIPs, m)
+		}
+		m.hits++
+		numMatchedIPsFound++
+
+		if stats != nil {
+			stats.add(lineNumber, line, ip, foundIn, m.score)
+		}
+
+		if p.ToCSVFile == "" && !p.Unique {
+			var hits string
+			if m.hits > 1 {
+				hits = fmt.Sprintf(", hit %d", m.hits)
+			}
+			for _, s := range shown {
+				fmt.Printf("%s  <==  %s  (%s%s)\n", line, s, m.score, hits)
+			}
 		}
 	}
 
-	findIPs := regexp.MustCompile(`(^|[^\d\.])(\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3})([^\d\.]|$)`)
-	var numIPsFound, numDupes int
-	dupes := make(map[string][]string)
-	matchedIPs := [][]string{{"IP", "Info"}}
+	// In batch mode we collect all IPs first and look them all up at once,
+	// see interval.Tree.LookupBatch.
+	type pendingIP struct {
+		lineNumber int
+		line       string
+		ip         string
+	}
+	var pending []pendingIP
+	var pendingIPNumbers []uint32
 
-	readFileOrURL(p.InputFileORURL, func(lineNumber int, line string) error {
+	err = readFileOrURL(p.InputFileORURL, func(lineNumber int, line string) error {
 		ipsFound := findIPs.FindAllStringSubmatch(line, -1)
 
 		for _, match := range ipsFound {
@@ -150,62 +438,198 @@ func CheckAgainstIPRanges(p CheckAgainstIPRangesParams) (numMatchedIPsFound int,
 			if err != nil {
 				return err
 			}
+			uniqueIPs.Add(r.Start())
 
-			res, err := ipRanges.FindFirstOverlapping(r)
-			if err == nil {
-				// Found overlapping range.
-				info := fmt.Sprintf("%s | %s - %s", res.Payload, res.Interval.IPRangeMin, res.Interval.IPRangeMax)
-				if _, found := dupes[ip]; found {
-					numDupes++
-				} else {
-					matchedIPs = append(matchedIPs, []string{ip, info})
-				}
-				dupes[ip] = append(dupes[ip], info)
-
-				if p.ToCSVFile == "" {
-					fmt.Printf("%s  <==  %-5s | %s - %s\n", line, res.Payload, res.Interval.IPRangeMin, res.Interval.IPRangeMax)
-				}
-				numMatchedIPsFound++
+			if p.Batch {
+				pending = append(pending, pendingIP{lineNumber: lineNumber, line: line, ip: ip})
+				pendingIPNumbers = append(pendingIPNumbers, r.Start())
 				continue
 			}
 
-			ipn := iputil.IP2Long(ip)
-
-			if srcID, found := ipNumbers[ipn]; found {
-				// Found matching IP.
-				src := ipNumberSources[srcID]
-
-				if _, found := dupes[ip]; found {
-					numDupes++
-				} else {
-					matchedIPs = append(matchedIPs, []string{ip, src})
-				}
-				dupes[ip] = append(dupes[ip], src)
-
-				if p.ToCSVFile == "" {
-					fmt.Printf("%s  <==  %s\n", line, src)
-				}
-				numMatchedIPsFound++
-			}
+			// Ignore the error, no results simply means no match.
+			results, _ := ipRanges.FindAllOverlapping(r)
+			checkIP(lineNumber, line, ip, results)
 		}
 
 		return nil
 	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not check IPs in %s", p.InputFileORURL)
+	}
+
+	if p.Batch {
+		for i, results := range ipRanges.LookupBatch(pendingIPNumbers) {
+			checkIP(pending[i].lineNumber, pending[i].line, pending[i].ip, results)
+		}
+	}
+
+	if p.Unique && p.ToCSVFile == "" {
+		for _, m := range matchedIPs {
+			fmt.Printf("%-15s  <==  %s  (%s, %s)\n", m.ip, m.info, m.score, pluralize(m.hits, "hit"))
+		}
+	}
 
 	fmt.Printf(
-		"\nFound %d matches | Checked %d IPs against %d ranges and %d blocked or flagged IPs (%d dupes)\n",
-		numMatchedIPsFound, numIPsFound, numRanges, len(ipNumbers), numDupes,
+		"\nFound %d matches (%d unique IPs) | Checked %d IPs (%d unique) against %d ranges and %d blocked or flagged IPs (%d dupes)\n",
+		numMatchedIPsFound, len(matchedIPs), numIPsFound, uniqueIPs.Len(), numRanges, numIPs, numDupes,
 	)
+	if numAllowed > 0 {
+		fmt.Printf("Skipped %d IPs found in allowed lists\n", numAllowed)
+	}
+
+	if stats != nil {
+		stats.ipsChecked, stats.matches, stats.dupes = numIPsFound, numMatchedIPsFound, numDupes
+		stats.uniqueIPsChecked, stats.uniqueMatches = uniqueIPs.Len(), len(matchedIPs)
+
+		topN := p.ReportTopN
+		if topN <= 0 {
+			topN = defaultReportTopN
+		}
+		report := stats.report(topN)
+
+		if p.Report {
+			report.Print()
+		}
+		if p.ReportFile != "" {
+			err = report.WriteJSONFile(p.ReportFile)
+			if err != nil {
+				return nil, err
+			}
+			fmt.Printf("Wrote %s\n", p.ReportFile)
+		}
+	}
 
 	if p.ToCSVFile != "" {
 		err = matchedIPsToCSVFile(p.ToCSVFile, matchedIPs)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 		fmt.Printf("Wrote %s\n", p.ToCSVFile)
 	}
 
-	return numMatchedIPsFound, nil
+	return &Result{
+		Matches:          numMatchedIPsFound,
+		UniqueMatches:    len(matchedIPs),
+		IPsChecked:       numIPsFound,
+		UniqueIPsChecked: uniqueIPs.Len(),
+		Dupes:            numDupes,
+		Allowed:          numAllowed,
+	}, nil
+}
+
+// matchesAny returns true if the list name matches any of the patterns (see
+// path.Match for the pattern syntax).
+func matchesAny(patterns []string, name string) (bool, error) {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, name)
+		if err != nil {
+			return false, errors.Wrapf(err, "invalid list pattern: %s", pattern)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// selectMatches returns the ranges to report out of all ranges containing an
+// IP, according to the given match mode.
+func selectMatches(results []interval.Result[int32], match string, lists []checkList) []interval.Result[int32] {
+	switch match {
+	case "narrowest":
+		return []interval.Result[int32]{interval.Narrowest(results)}
+	case "widest":
+		return []interval.Result[int32]{interval.Widest(results)}
+	case "priority":
+		// The narrowest of the ranges from the highest ranked sources.
+		var top []interval.Result[int32]
+		for _, res := range results {
+			if len(top) > 0 {
+				c := compareRank(*lists[res.Payload].source, *lists[top[0].Payload].source)
+				if c < 0 {
+					continue
+				}
+				if c > 0 {
+					top = top[:0]
+				}
+			}
+			top = append(top, res)
+		}
+		return []interval.Result[int32]{interval.Narrowest(top)}
+	case "all":
+		ranked := make([]interval.Result[int32], len(results))
+		copy(ranked, results)
+		sort.SliceStable(ranked, func(i, j int) bool {
+			return compareRank(*lists[ranked[i].Payload].source, *lists[ranked[j].Payload].source) > 0
+		})
+		return ranked
+	default:
+		return results[:1]
+	}
+}
+
+// listResults returns a result for each list containing each of the ranges
+// found, keeping their order. The payload of each range found is its
+// position in rangeLists, the payload of each result the ID of the list.
+func listResults(results []interval.Result[int32], rangeLists [][]int32) []interval.Result[int32] {
+	res := make([]interval.Result[int32], 0, len(results))
+	for _, r := range results {
+		for _, id := range rangeLists[r.Payload] {
+			res = append(res, interval.Result[int32]{Interval: r.Interval, Payload: id})
+		}
+	}
+	return res
+}
+
+// checkList is a list (a vendor, FireHOL IP set or other source) loaded by
+// Check.
+type checkList struct {
+	name string
+	// info is only set for FireHOL IP sets, if showing additional info.
+	info    string
+	source  *Source
+	updated *time.Time
+	allowed bool
+	// selected is true if the filter selects the source of the list.
+	selected bool
+}
+
+func (l *checkList) scored() scoredList {
+	return scoredList{name: l.name, source: l.source, updated: l.updated}
+}
+
+func (l *checkList) String() string {
+	if l.info != "" {
+		return l.name + " | " + l.info
+	}
+	return l.name
+}
+
+// cidrInterval returns the interval covered by a CIDR or single IP.
+func cidrInterval(cidr string) (interval.Interval, error) {
+	c, err := iputil.ParseCIDR(cidr)
+	if err != nil {
+		return interval.Interval{}, err
+	}
+
+	r := c.Range()
+	return interval.NewInterval(iputil.Long2IP(r.Start), iputil.Long2IP(r.End))
+}
+
+// matchedIP is an IP found in any range or list, with the number of times it
+// was found in the input.
+type matchedIP struct {
+	ip    string
+	info  string
+	hits  int
+	score Score
+}
+
+func pluralize(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
 }
 
 type IPMap struct {
@@ -213,14 +637,20 @@ type IPMap struct {
 	IPs    map[uint32]bool
 }
 
-func matchedIPsToCSVFile(file string, matchedIPs [][]string) error {
+func matchedIPsToCSVFile(file string, matchedIPs []*matchedIP) error {
+	records := [][]string{{"IP", "Hits", "Info", "Score", "Verdict"}}
+	for _, m := range matchedIPs {
+		score := strconv.FormatFloat(m.score.Score, 'f', -1, 64)
+		records = append(records, []string{m.ip, strconv.Itoa(m.hits), m.info, score, m.score.Verdict})
+	}
+
 	f, err := os.Create(file)
 	if err != nil {
 		return errors.Wrapf(err, "could not create CSV file: %s", file)
 	}
 	defer f.Close()
 
-	err = csv.NewWriter(f).WriteAll(matchedIPs)
+	err = csv.NewWriter(f).WriteAll(records)
 	if err != nil {
 		return errors.Wrapf(err, "could not write %d matched IPs to CSV file: %s", len(matchedIPs), file)
 	}
@@ -229,19 +659,12 @@ func matchedIPsToCSVFile(file string, matchedIPs [][]string) error {
 }
 
 func readCSVFileOrURL(fileOrURL string, forEachRecord func(recordNumber int, record []string) error) error {
-	file := fileOrURL
-
-	_, err := os.Stat(fileOrURL)
+	file, _, err := localFile(fileOrURL)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return errors.Wrapf(err, "got unexpected error when trying to stat file (or URL): %s", fileOrURL)
-		}
-
-		// Treat this as a URL. Download its contents to a local temp location.
-		file, err = downloadURLToTempFile(fileOrURL)
-		if err != nil {
-			return err
-		}
+		return err
+	}
+	if file != fileOrURL {
+		defer os.Remove(file)
 	}
 
 	// Treat file as a local CSV file at this point.
@@ -276,19 +699,12 @@ func readCSVFileOrURL(fileOrURL string, forEachRecord func(recordNumber int, rec
 }
 
 func readFileOrURL(fileOrURL string, forEachLine func(lineNumber int, line string) error) error {
-	file := fileOrURL
-
-	_, err := os.Stat(fileOrURL)
+	file, _, err := localFile(fileOrURL)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return errors.Wrapf(err, "got unexpected error when trying to stat file (or URL): %s", fileOrURL)
-		}
-
-		// Treat this as a URL. Download its contents to a local temp location.
-		file, err = downloadURLToTempFile(fileOrURL)
-		if err != nil {
-			return err
-		}
+		return err
+	}
+	if file != fileOrURL {
+		defer os.Remove(file)
 	}
 
 	// Treat file as a local file at this point.
@@ -319,27 +735,52 @@ func readFileOrURL(fileOrURL string, forEachLine func(lineNumber int, line strin
 	return nil
 }
 
-func downloadURLToTempFile(url string) (filename string, err error) {
+// localFile returns the path of a local file, or downloads a URL to a temp
+// file and returns its path. updated is the modification time of the local
+// file, or the Last-Modified time of the URL if the server sends one. The
+// caller removes the temp file when done with it, i.e. if file isn't
+// fileOrURL.
+func localFile(fileOrURL string) (file string, updated *time.Time, err error) {
+	s, err := os.Stat(fileOrURL)
+	if err == nil {
+		t := s.ModTime()
+		return fileOrURL, &t, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", nil, errors.Wrapf(err, "got unexpected error when trying to stat file (or URL): %s", fileOrURL)
+	}
+
+	// Treat this as a URL. Download its contents to a local temp location.
+	return downloadURLToTempFile(fileOrURL)
+}
+
+func downloadURLToTempFile(url string) (filename string, updated *time.Time, err error) {
 	res, err := http.Get(url)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to download data from URL: %s", url)
+		return "", nil, errors.Wrapf(err, "failed to download data from URL: %s", url)
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode >= 400 {
-		return "", errors.Errorf("failed to download data from URL: %s - got status code: %d", url, res.StatusCode)
+		return "", nil, errors.Errorf("failed to download data from URL: %s - got status code: %d", url, res.StatusCode)
+	}
+
+	if t, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		updated = &t
 	}
 
 	// Create a temp file.
 	f, err := os.CreateTemp(os.TempDir(), "ips-to-check-csv")
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to create a temp file to store data in")
+		return "", nil, errors.Wrapf(err, "failed to create a temp file to store data in")
 	}
 	defer f.Close()
 
 	_, err = io.Copy(f, res.Body)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to read data from HTTP response from URL: %s", url)
+		os.Remove(f.Name())
+		return "", nil, errors.Wrapf(err, "failed to read data from HTTP response from URL: %s", url)
 	}
 
-	return f.Name(), nil
+	return f.Name(), updated, nil
 }
@@ -1,8 +1,18 @@
 package ipcheck
 
 import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/anrid/ipcheck/pkg/iputil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,3 +25,770 @@ func TestIPCheck(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, 3, found)
 }
+
+func TestIPCheckLocalRanges(t *testing.T) {
+	for _, index := range []string{"tree", "flat", "radix"} {
+		for _, batch := range []bool{false, true} {
+			found, err := CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+				InputFileORURL:       "../../data/test-ips.txt",
+				IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+				Index:                index,
+				Batch:                batch,
+			})
+			require.NoError(t, err)
+			require.Equal(t, 3, found)
+		}
+	}
+}
+
+func TestMatchModes(t *testing.T) {
+	r := require.New(t)
+
+	// 34.64.161.255 is covered by both 34.64.160.0/19 (GCP) and the broader
+	// 34.64.0.0/10 from the FireHOL test file.
+	for _, c := range []struct {
+		match string
+		want  []string
+	}{
+		{"narrowest", []string{"GCP"}},
+		{"widest", []string{"pushing_inertia_blocklist"}},
+		{"all", []string{"pushing_inertia_blocklist", "GCP"}},
+	} {
+		for _, batch := range []bool{false, true} {
+			csvFile := filepath.Join(t.TempDir(), "matches.csv")
+
+			found, err := CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+				InputFileORURL:       "../../data/test-ips.txt",
+				IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+				FireHOLFile:          "../../data/test-firehol.ips",
+				ToCSVFile:            csvFile,
+				Match:                c.match,
+				Batch:                batch,
+			})
+			r.NoError(err)
+			r.Equal(5, found)
+
+			data, err := os.ReadFile(csvFile)
+			r.NoError(err)
+
+			records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+			r.NoError(err)
+
+			var infos []string
+			for _, rec := range records {
+				if rec[0] == "34.64.161.255" {
+					infos = strings.Split(rec[2], "; ")
+				}
+			}
+			r.Len(infos, len(c.want), c.match)
+			for i, list := range c.want {
+				r.True(strings.HasPrefix(infos[i], list+" | "), "%s: %s", c.match, infos[i])
+			}
+		}
+	}
+
+	_, err := CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+		InputFileORURL:       "../../data/test-ips.txt",
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+		Match:                "best",
+	})
+	r.Error(err)
+
+	// "all" reports every list containing a range, also if several FireHOL
+	// IP sets contain the same CIDR.
+	fireHOLFile := filepath.Join(t.TempDir(), "firehol.ips")
+	r.NoError(os.WriteFile(fireHOLFile, []byte("# set_a | A\n34.64.0.0/10\n# set_b | B\n34.64.0.0/10\n"), 0644))
+
+	for _, batch := range []bool{false, true} {
+		csvFile := filepath.Join(t.TempDir(), "matches.csv")
+
+		_, err = CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+			InputFileORURL:       "../../data/test-ips.txt",
+			IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+			FireHOLFile:          fireHOLFile,
+			ToCSVFile:            csvFile,
+			Match:                "all",
+			Batch:                batch,
+		})
+		r.NoError(err)
+
+		data, err := os.ReadFile(csvFile)
+		r.NoError(err)
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		r.NoError(err)
+		r.Equal("34.64.161.255", records[1][0])
+		r.Equal("set_a | 34.64.0.0 - 34.127.255.255; set_b | 34.64.0.0 - 34.127.255.255; GCP | 34.64.160.0 - 34.64.191.255", records[1][2])
+	}
+}
+
+func TestReport(t *testing.T) {
+	r := require.New(t)
+
+	reportFile := filepath.Join(t.TempDir(), "report.json")
+
+	found, err := CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+		InputFileORURL:       "../../data/test-ips.txt",
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+		FireHOLFile:          "../../data/test-firehol.ips",
+		Match:                "all",
+		Report:               true,
+		ReportFile:           reportFile,
+		ReportTopN:           2,
+		// Ignore the age of the test files.
+		Scoring: Scoring{HalfLife: -1},
+	})
+	r.NoError(err)
+	r.Equal(5, found)
+
+	data, err := os.ReadFile(reportFile)
+	r.NoError(err)
+
+	var report Report
+	r.NoError(json.Unmarshal(data, &report))
+
+	r.Equal(6, report.IPsChecked)
+	r.Equal(5, report.Matches)
+	r.Equal(50.0, report.DatacenterPercent)
+	r.Equal([]Count{
+		{Name: "AWS", Count: 1},
+		{Name: "Azure", Count: 1},
+		{Name: "GCP", Count: 1},
+		{Name: "firehol_level1", Count: 1},
+		{Name: "iblocklist_org_joost", Count: 1},
+		{Name: "pushing_inertia_blocklist", Count: 1},
+	}, report.ByList)
+	r.Equal([]Count{{Name: "blocklist", Count: 3}, {Name: "datacenter", Count: 3}}, report.ByCategory)
+	r.Len(report.BySubnet, 2)
+	r.Equal([]Count{{Name: "review", Count: 3}, {Name: "allow", Count: 2}}, report.ByVerdict)
+	r.Equal([]IPHits{{
+		IP:              "34.64.161.255",
+		Hits:            1,
+		Lists:           []string{"pushing_inertia_blocklist", "GCP"},
+		Score:           44,
+		Verdict:         VerdictReview,
+		FirstLineNumber: 1,
+		FirstLine:       "34.64.161.255",
+		LastLineNumber:  1,
+		LastLine:        "34.64.161.255",
+	}}, report.TopIPs[:1])
+
+	// The statistics don't depend on which matches are reported.
+	for _, c := range []struct {
+		match  string
+		filter MatchFilter
+	}{
+		{"first", MatchFilter{}},
+		{"narrowest", MatchFilter{}},
+		{"first", MatchFilter{Categories: []string{CategoryBlocklist}}},
+	} {
+		_, err = CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+			InputFileORURL:       "../../data/test-ips.txt",
+			IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+			FireHOLFile:          "../../data/test-firehol.ips",
+			Match:                c.match,
+			Filter:               c.filter,
+			ReportFile:           reportFile,
+		})
+		r.NoError(err)
+
+		data, err = os.ReadFile(reportFile)
+		r.NoError(err)
+
+		var other Report
+		r.NoError(json.Unmarshal(data, &other))
+		r.Equal(50.0, other.DatacenterPercent, c.match)
+		r.Equal(report.ByList, other.ByList, c.match)
+		r.Equal(report.BySource, other.BySource, c.match)
+		r.Equal(report.ByCategory, other.ByCategory, c.match)
+	}
+}
+
+func TestUniqueIPs(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	inputFile := filepath.Join(dir, "ips.txt")
+	csvFile := filepath.Join(dir, "matches.csv")
+	reportFile := filepath.Join(dir, "report.json")
+
+	r.NoError(os.WriteFile(inputFile, []byte("3.2.35.193\n3.2.35.193, 3.2.35.194\n1.1.1.1\nip=3.2.35.193\n"), 0644))
+
+	found, err := CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+		InputFileORURL:       inputFile,
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+		ToCSVFile:            csvFile,
+		ReportFile:           reportFile,
+		Unique:               true,
+	})
+	r.NoError(err)
+	r.Equal(4, found)
+
+	data, err := os.ReadFile(csvFile)
+	r.NoError(err)
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	r.NoError(err)
+	r.Equal([][]string{
+		{"IP", "Hits", "Info", "Score", "Verdict"},
+		{"3.2.35.193", "3", "AWS | 3.2.35.192 - 3.2.35.255", "20", "allow"},
+		{"3.2.35.194", "1", "AWS | 3.2.35.192 - 3.2.35.255", "20", "allow"},
+	}, records)
+
+	data, err = os.ReadFile(reportFile)
+	r.NoError(err)
+
+	var report Report
+	r.NoError(json.Unmarshal(data, &report))
+	r.Equal(5, report.IPsChecked)
+	r.Equal(3, report.UniqueIPsChecked)
+	r.Equal(4, report.Matches)
+	r.Equal(2, report.UniqueMatches)
+	r.Equal(2, report.Dupes)
+	r.Equal(3, report.TopIPs[0].Hits)
+	r.Equal(4, report.TopIPs[0].LastLineNumber)
+}
+
+func TestCheckErrors(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	badRanges := filepath.Join(dir, "ranges.csv")
+	r.NoError(os.WriteFile(badRanges, []byte("cidr,vendor\n1.2.3.0/24,x\n"), 0644))
+
+	for _, p := range []CheckAgainstIPRangesParams{
+		{InputFileORURL: "../../data/test-ips.txt", IPRangesCSVFileOrURL: filepath.Join(dir, "missing.csv")},
+		{InputFileORURL: "../../data/test-ips.txt", IPRangesCSVFileOrURL: badRanges},
+		{InputFileORURL: filepath.Join(dir, "missing.txt"), IPRangesCSVFileOrURL: "../../data/test-ranges.csv"},
+	} {
+		_, err := Check(p)
+		r.Error(err)
+	}
+
+	res, err := Check(CheckAgainstIPRangesParams{
+		InputFileORURL:       "../../data/test-ips.txt",
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+	})
+	r.NoError(err)
+	r.Equal(3, res.Matches)
+	r.Equal(6, res.IPsChecked)
+	r.Equal(0.5, res.MatchRatio())
+}
+
+func TestLookup(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+
+	ranges, err := os.ReadFile("../../data/test-ranges.csv")
+	r.NoError(err)
+	rangesFile := filepath.Join(dir, "ranges.csv")
+	r.NoError(os.WriteFile(rangesFile, append(ranges, `"2600:1900::/28","x","x","GCP"`+"\n"...), 0644))
+
+	asnFile := filepath.Join(dir, "asn.tsv")
+	r.NoError(os.WriteFile(asnFile, []byte(
+		"34.64.0.0\t34.127.255.255\t396982\tUS\tGOOGLE-CLOUD-PLATFORM\n"+
+			"34.128.0.0\t34.128.0.255\t0\tNone\tNot routed\n"+
+			"2600:1900::\t2600:190f:ffff:ffff:ffff:ffff:ffff:ffff\t396982\tUS\tGOOGLE-CLOUD-PLATFORM\n",
+	), 0644))
+
+	l, err := NewLookup(LookupParams{
+		IPRangesCSVFileOrURL: rangesFile,
+		FireHOLFile:          "../../data/test-firehol.ips",
+		ASNFile:              asnFile,
+	})
+	r.NoError(err)
+
+	lists := func(res *LookupResult) (lists []string) {
+		for _, m := range res.Matches {
+			lists = append(lists, m.List+" "+m.CIDR)
+		}
+		return lists
+	}
+
+	res, err := l.Lookup("34.64.161.255")
+	r.NoError(err)
+	r.Equal([]string{"pushing_inertia_blocklist 34.64.0.0/10", "GCP 34.64.160.0/19"}, lists(res))
+	r.Equal(CategoryBlocklist, res.Matches[0].Category)
+	r.Equal(CategoryDatacenter, res.Matches[1].Category)
+	r.Equal("34.64.160.0", res.Matches[1].First)
+	r.Equal("34.64.191.255", res.Matches[1].Last)
+	r.NotNil(res.Matches[1].Updated)
+	r.Equal(396982, res.ASN.Number)
+
+	res, err = l.Lookup("2600:1900:4000::1")
+	r.NoError(err)
+	r.Equal([]string{"GCP 2600:1900::/28"}, lists(res))
+	r.Equal("2600:190f:ffff:ffff:ffff:ffff:ffff:ffff", res.Matches[0].Last)
+	r.Equal(396982, res.ASN.Number)
+
+	// A CIDR matches all sources overlapping any part of it.
+	res, err = l.Lookup("8.8.0.0/16")
+	r.NoError(err)
+	r.Equal([]string{"firehol_level1 8.8.8.8/32"}, lists(res))
+	r.Nil(res.ASN)
+
+	res, err = l.Lookup("34.128.0.1")
+	r.NoError(err)
+	r.Empty(res.Matches)
+	r.Nil(res.ASN)
+
+	_, err = l.Lookup("not an IP")
+	r.Error(err)
+
+	list, found := l.List("GCP")
+	r.True(found)
+	r.Equal(CategoryDatacenter, list.Category)
+	r.Equal(2, list.CIDRs)
+	r.Equal(uint64(8192), list.IPv4s)
+	r.Len(l.Lists(), 6)
+
+	matches, err := l.Contains("GCP", "34.64.161.255")
+	r.NoError(err)
+	r.Len(matches, 1)
+	matches, err = l.Contains("firehol_level1", "34.64.161.255")
+	r.NoError(err)
+	r.Empty(matches)
+	_, err = l.Contains("unknown", "34.64.161.255")
+	r.Error(err)
+
+	r.Equal(LookupStats{Lists: 6, Prefixes: 7, ASNRanges: 2}, l.Stats())
+
+	// The checker skips IPv6 ranges instead of treating them as IPv4 ones.
+	numFound, err := CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+		InputFileORURL:       "../../data/test-ips.txt",
+		IPRangesCSVFileOrURL: rangesFile,
+	})
+	r.NoError(err)
+	r.Equal(3, numFound)
+}
+
+func TestFireHOLCache(t *testing.T) {
+	r := require.New(t)
+
+	data, err := os.ReadFile("../../data/test-firehol.ips")
+	r.NoError(err)
+
+	// A CIDR also found in another set must be cached for both.
+	data = append(data, "# shared_set | Shared (1 CIDRs, 0 IPs)\n34.64.0.0/10\n"...)
+
+	fireHOLFile := filepath.Join(t.TempDir(), "firehol.ips")
+	r.NoError(os.WriteFile(fireHOLFile, data, 0644))
+
+	loaded, err := loadFireHOL(fireHOLFile)
+	r.NoError(err)
+	r.Len(loaded.ranges, 3)
+
+	cached, err := readFireHOLCache(fireHOLFile)
+	r.NoError(err)
+	r.Nil(cached)
+
+	r.NoError(writeFireHOLCache(fireHOLFile, loaded))
+
+	cached, err = readFireHOLCache(fireHOLFile)
+	r.NoError(err)
+	r.Equal(loaded.ranges, cached.ranges)
+	r.Equal(loaded.numRanges, cached.numRanges)
+	r.Equal(loaded.ips, cached.ips)
+	r.Equal(loaded.sources, cached.sources)
+
+	// A changed FireHOL file invalidates the cache.
+	r.NoError(os.WriteFile(fireHOLFile, append(data, "1.1.1.1\n"...), 0644))
+	_, err = readFireHOLCache(fireHOLFile)
+	r.Error(err)
+
+	found, err := CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+		InputFileORURL:       "../../data/test-ips.txt",
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+		FireHOLFile:          fireHOLFile,
+		CacheFireHOL:         true,
+	})
+	r.NoError(err)
+	r.Equal(5, found)
+
+	cached, err = readFireHOLCache(fireHOLFile)
+	r.NoError(err)
+	r.Len(cached.ips, 2)
+
+	// No temporary files are left behind.
+	files, err := os.ReadDir(filepath.Dir(fireHOLFile))
+	r.NoError(err)
+	r.Len(files, 2)
+
+	// Failing to write the cache doesn't fail the check.
+	r.NoError(os.Remove(fireHOLCacheFile(fireHOLFile)))
+	r.NoError(os.Mkdir(fireHOLCacheFile(fireHOLFile), 0755))
+	r.Error(writeFireHOLCache(fireHOLFile, loaded))
+
+	found, err = CheckAgainstIPRanges(CheckAgainstIPRangesParams{
+		InputFileORURL:       "../../data/test-ips.txt",
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+		FireHOLFile:          fireHOLFile,
+		CacheFireHOL:         true,
+	})
+	r.NoError(err)
+	r.Equal(5, found)
+}
+
+func TestAllow(t *testing.T) {
+	r := require.New(t)
+
+	res, err := Check(CheckAgainstIPRangesParams{
+		InputFileORURL:       "../../data/test-ips.txt",
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+		Match:                "all",
+		Allow:                []string{"A*"},
+	})
+	r.NoError(err)
+	r.Equal(1, res.Matches)
+	r.Equal(2, res.Allowed)
+
+	_, err = Check(CheckAgainstIPRangesParams{
+		InputFileORURL:       "../../data/test-ips.txt",
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+		Allow:                []string{"["},
+	})
+	r.Error(err)
+}
+
+func TestDiff(t *testing.T) {
+	r := require.New(t)
+
+	rng := func(list, start, end string) Range {
+		return Range{Start: iputil.IP2Long(start), End: iputil.IP2Long(end), List: list}
+	}
+
+	diffs := Diff([]Range{
+		rng("AWS", "10.0.0.0", "10.0.0.255"),
+		rng("GCP", "20.0.0.0", "20.0.0.255"),
+		rng("Old", "30.0.0.0", "30.0.0.0"),
+	}, []Range{
+		rng("AWS", "10.0.0.0", "10.0.0.127"),
+		rng("AWS", "10.0.1.0", "10.0.1.0"),
+		rng("GCP", "20.0.0.0", "20.0.0.127"),
+		rng("GCP", "20.0.0.128", "20.0.0.255"),
+		rng("New", "40.0.0.0", "40.0.0.3"),
+	})
+
+	r.Len(diffs, 3)
+
+	r.Equal("AWS", diffs[0].List)
+	r.Equal("changed", diffs[0].Status)
+	r.Equal([]iputil.IPRange{{Start: iputil.IP2Long("10.0.1.0"), End: iputil.IP2Long("10.0.1.0")}}, diffs[0].Added)
+	r.Equal([]iputil.IPRange{{Start: iputil.IP2Long("10.0.0.128"), End: iputil.IP2Long("10.0.0.255")}}, diffs[0].Removed)
+	r.Equal(uint64(1), diffs[0].AddedIPs)
+	r.Equal(uint64(128), diffs[0].RemovedIPs)
+
+	r.Equal("New", diffs[1].List)
+	r.Equal("added", diffs[1].Status)
+	r.Equal(uint64(4), diffs[1].AddedIPs)
+
+	r.Equal("Old", diffs[2].List)
+	r.Equal("removed", diffs[2].Status)
+	r.Equal(uint64(1), diffs[2].RemovedIPs)
+}
+
+func TestSources(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	listFile := filepath.Join(dir, "office.txt")
+	cloudFile := filepath.Join(dir, "ip-ranges.json")
+
+	r.NoError(os.WriteFile(listFile, []byte("# Office IPs\n34.64.161.0/24\n\n8.8.8.8\n"), 0644))
+	r.NoError(os.WriteFile(cloudFile, []byte(`{
+  "syncToken": "1680000000",
+  "prefixes": [{"ip_prefix": "3.2.35.0/24", "region": "us-east-1", "service": "AMAZON"}],
+  "ipv6_prefixes": [{"ipv6_prefix": "2600:1f00::/24", "region": "us-east-1", "service": "AMAZON"}]
+}`), 0644))
+
+	sources := []Source{
+		{Name: "office", Type: SourceList, Path: listFile, Category: CategoryInternal, Severity: "low", Priority: 10},
+		{Name: "aws", Type: SourceCloudJSON, Path: cloudFile},
+	}
+
+	check := func(match string, filter MatchFilter) (*Result, map[string]string) {
+		csvFile := filepath.Join(t.TempDir(), "matches.csv")
+
+		res, err := Check(CheckAgainstIPRangesParams{
+			InputFileORURL:       "../../data/test-ips.txt",
+			IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+			FireHOLFile:          "../../data/test-firehol.ips",
+			Sources:              sources,
+			Filter:               filter,
+			ToCSVFile:            csvFile,
+			Match:                match,
+		})
+		r.NoError(err)
+
+		data, err := os.ReadFile(csvFile)
+		r.NoError(err)
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		r.NoError(err)
+
+		infos := make(map[string]string)
+		for _, rec := range records[1:] {
+			infos[rec[0]] = rec[2]
+		}
+		return res, infos
+	}
+
+	// 34.64.161.255 is covered by the office list (the highest priority),
+	// GCP and a FireHOL list.
+	_, infos := check("priority", MatchFilter{})
+	r.True(strings.HasPrefix(infos["34.64.161.255"], "office | 34.64.161.0 - "), infos["34.64.161.255"])
+
+	_, infos = check("all", MatchFilter{})
+	r.True(strings.HasPrefix(infos["34.64.161.255"], "office | "), infos["34.64.161.255"])
+	r.Len(strings.Split(infos["34.64.161.255"], "; "), 3)
+	// 3.2.35.193 is in both the ranges CSV and the AWS feed.
+	r.Equal("aws | 3.2.35.0 - 3.2.35.255; AWS | 3.2.35.192 - 3.2.35.255", infos["3.2.35.193"])
+
+	res, infos := check("first", MatchFilter{Categories: []string{CategoryInternal}})
+	r.Equal(2, res.Matches)
+	r.Contains(infos, "34.64.161.255")
+	r.Contains(infos, "8.8.8.8")
+
+	res, _ = check("first", MatchFilter{MinSeverity: "high"})
+	r.Equal(0, res.Matches)
+
+	_, err := Check(CheckAgainstIPRangesParams{
+		InputFileORURL: "../../data/test-ips.txt",
+		Sources:        []Source{{Name: "x", Type: "csv", Path: listFile}},
+	})
+	r.Error(err)
+
+	// Lookups keep the source of each match.
+	l, err := NewLookup(LookupParams{
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+		FireHOLFile:          "../../data/test-firehol.ips",
+		Sources:              sources,
+	})
+	r.NoError(err)
+
+	lr, err := l.Lookup("34.64.161.255")
+	r.NoError(err)
+	r.Len(lr.Matches, 3)
+	lr.Rank()
+	r.Equal("office", lr.Matches[0].Source)
+	r.Equal(CategoryInternal, lr.Matches[0].Category)
+	r.Equal(listFile, lr.Matches[0].Path)
+	r.NoError(lr.Filter(MatchFilter{Categories: []string{CategoryDatacenter}}))
+	r.Len(lr.Matches, 1)
+	r.Equal("GCP", lr.Matches[0].List)
+	r.Equal("ip-ranges", lr.Matches[0].Source)
+	r.Error(lr.Filter(MatchFilter{MinSeverity: "extreme"}))
+
+	lr, err = l.Lookup("2600:1f00::1")
+	r.NoError(err)
+	r.Len(lr.Matches, 1)
+	r.Equal("aws", lr.Matches[0].List)
+	r.Equal("medium", lr.Matches[0].Severity)
+
+	ranges, err := LoadRanges("", "", sources...)
+	r.NoError(err)
+	r.Len(ranges, 3)
+	r.Equal(Range{Start: iputil.IP2Long("8.8.8.8"), End: iputil.IP2Long("8.8.8.8"), List: "office", Source: "office", Category: CategoryInternal}, ranges[1])
+	r.Equal(CategoryDatacenter, ranges[2].Category)
+
+	// Plain lists support ranges, labels and inline comments.
+	r.NoError(os.WriteFile(listFile, []byte("10.0.0.1-10.0.0.6  vpn  # VPN gateways\n10.0.1.1 # printer\n"), 0644))
+	ranges, err = LoadRanges("", "", sources[0])
+	r.NoError(err)
+	r.Len(ranges, 5)
+	r.Equal("vpn", ranges[0].List)
+	r.Equal(iputil.IP2Long("10.0.0.1"), ranges[0].Start)
+	r.Equal(iputil.IP2Long("10.0.0.6"), ranges[3].End)
+	r.Equal(Range{Start: iputil.IP2Long("10.0.1.1"), End: iputil.IP2Long("10.0.1.1"), List: "office", Source: "office", Category: CategoryInternal}, ranges[4])
+
+	// Ranges may have spaces around the '-'.
+	r.NoError(os.WriteFile(listFile, []byte("10.0.0.1 - 10.0.0.6  vpn\n"), 0644))
+	ranges, err = LoadRanges("", "", sources[0])
+	r.NoError(err)
+	r.Len(ranges, 4)
+	r.Equal(iputil.IP2Long("10.0.0.6"), ranges[3].End)
+
+	// Invalid entries fail with the line they're on, rather than matching
+	// 0.0.0.0.
+	for _, bad := range []string{"10.0.0.6-10.0.0.1", "foo", "10.0.0.1 - foo"} {
+		r.NoError(os.WriteFile(listFile, []byte("10.0.0.1\n"+bad+"\n"), 0644))
+		_, err = LoadRanges("", "", sources[0])
+		r.ErrorContains(err, "line 2", bad)
+
+		_, err = Check(CheckAgainstIPRangesParams{InputFileORURL: "../../data/test-ips.txt", Sources: sources[:1]})
+		r.ErrorContains(err, "line 2", bad)
+	}
+
+	_, err = cidrInterval("2600::1")
+	r.Error(err)
+
+	// Sources downloaded from a URL don't leave temp files behind.
+	srv := httptest.NewServer(http.FileServer(http.Dir("../../data")))
+	defer srv.Close()
+
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+
+	ranges, err = LoadRanges(srv.URL+"/test-ranges.csv", "", Source{Name: "remote", Type: SourceFireHOL, Path: srv.URL + "/test-firehol.ips"})
+	r.NoError(err)
+	r.NotEmpty(ranges)
+
+	files, err := os.ReadDir(tmpDir)
+	r.NoError(err)
+	r.Empty(files)
+}
+
+func TestScore(t *testing.T) {
+	r := require.New(t)
+
+	now := time.Now()
+	weekAgo := now.Add(-7 * 24 * time.Hour)
+	dc := &Source{Name: "ip-ranges", Category: CategoryDatacenter, Severity: "medium"}
+	high := &Source{Name: "firehol", Category: CategoryBlocklist, Severity: "high"}
+	weighted := &Source{Name: "abuse", Category: CategoryThreat, Severity: "low", Weight: float64Ptr(50)}
+
+	s := Scoring{}.withDefaults()
+	r.Equal(Score{Score: 0, Verdict: VerdictAllow}, s.score(nil, now))
+
+	// Datacenter ranges only count once.
+	res := s.score([]scoredList{{name: "AWS", source: dc}, {name: "aws", source: dc}}, now)
+	r.Equal(Score{Score: 20, Verdict: VerdictAllow, Datacenter: true}, res)
+
+	// Independent lists agreeing add up, the same list found twice doesn't.
+	res = s.score([]scoredList{{name: "a", source: weighted}, {name: "b", source: weighted}, {name: "b", source: weighted}}, now)
+	r.Equal(Score{Score: 75, Verdict: VerdictBlock, Lists: 2}, res)
+
+	res = s.score([]scoredList{{name: "a", source: high}, {name: "GCP", source: dc}}, now)
+	r.Equal(Score{Score: 68, Verdict: VerdictReview, Lists: 1, Datacenter: true}, res)
+
+	// Lists count half after a half-life.
+	res = s.score([]scoredList{{name: "a", source: high, updated: &weekAgo}}, now)
+	r.Equal(30.0, res.Score)
+
+	s = Scoring{SeverityWeights: map[string]float64{"high": 80}, ReviewAt: float64Ptr(10), BlockAt: float64Ptr(75), HalfLife: -1}.withDefaults()
+	res = s.score([]scoredList{{name: "a", source: high, updated: &weekAgo}}, now)
+	r.Equal(Score{Score: 80, Verdict: VerdictBlock, Lists: 1}, res)
+
+	// Weights and thresholds can be set to 0.
+	ignored := &Source{Name: "noisy", Category: CategoryBlocklist, Severity: "high", Weight: float64Ptr(0)}
+	s = Scoring{ReviewAt: float64Ptr(0)}.withDefaults()
+	res = s.score([]scoredList{{name: "a", source: ignored}}, now)
+	r.Equal(Score{Score: 0, Verdict: VerdictReview, Lists: 1}, res)
+
+	r.Error(Scoring{SeverityWeights: map[string]float64{"extreme": 10}}.Validate())
+	r.Error(Scoring{SeverityWeights: map[string]float64{"high": 110}}.Validate())
+	r.Error(Scoring{ReviewAt: float64Ptr(80), BlockAt: float64Ptr(70)}.Validate())
+	r.Error(Scoring{BlockAt: float64Ptr(20)}.Validate())
+	r.NoError(Scoring{ReviewAt: float64Ptr(10)}.Validate())
+	r.NoError(Scoring{ReviewAt: float64Ptr(0), BlockAt: float64Ptr(0)}.Validate())
+	r.Error(Source{Name: "x", Type: SourceList, Path: "x", Weight: float64Ptr(-1)}.Validate())
+	r.NoError(Source{Name: "x", Type: SourceList, Path: "x", Weight: float64Ptr(0)}.Validate())
+
+	// Lookups score all matches.
+	l, err := NewLookup(LookupParams{
+		IPRangesCSVFileOrURL: "../../data/test-ranges.csv",
+		FireHOLFile:          "../../data/test-firehol.ips",
+		Scoring:              Scoring{HalfLife: -1},
+	})
+	r.NoError(err)
+	lr, err := l.Lookup("34.64.161.255")
+	r.NoError(err)
+	r.Equal(Score{Score: 44, Verdict: VerdictReview, Lists: 1, Datacenter: true}, lr.Score)
+
+	// FireHOL IP sets are as old as their source file date, or else the file.
+	name, info, updated := splitFireHOLHeader("old_set | Old | https://old.example.com | updated 2023-04-01T09:09:53Z (1 CIDRs, 0 IPs)")
+	r.Equal("old_set", name)
+	r.Equal("Old | https://old.example.com (1 CIDRs, 0 IPs)", info)
+	r.Equal(time.Date(2023, 4, 1, 9, 9, 53, 0, time.UTC), *updated)
+
+	fireHOLFile := filepath.Join(t.TempDir(), "firehol.ips")
+	r.NoError(os.WriteFile(fireHOLFile, []byte("# old_set | Old | https://old.example.com | updated 2023-04-01T09:09:53Z (1 CIDRs, 0 IPs)\n10.0.0.0/8\n# new_set | New | https://new.example.com (1 CIDRs, 0 IPs)\n10.0.0.0/8\n"), 0644))
+	fi, err := os.Stat(fireHOLFile)
+	r.NoError(err)
+
+	l, err = NewLookup(LookupParams{FireHOLFile: fireHOLFile})
+	r.NoError(err)
+	lists := l.Lists()
+	r.Len(lists, 2)
+	r.Equal(*updated, *lists[0].Updated)
+	r.Equal(fi.ModTime(), *lists[1].Updated)
+
+	// The old set has all but decayed, only the new set counts.
+	lr, err = l.Lookup("10.1.2.3")
+	r.NoError(err)
+	r.Equal(Score{Score: 30, Verdict: VerdictReview, Lists: 2}, lr.Score)
+
+	inputFile := filepath.Join(t.TempDir(), "ips.txt")
+	r.NoError(os.WriteFile(inputFile, []byte("10.1.2.3\n"), 0644))
+
+	// Check scores the same, with and without (and from) the cache.
+	for i := 0; i < 3; i++ {
+		csvFile := filepath.Join(t.TempDir(), "matches.csv")
+
+		res, err := Check(CheckAgainstIPRangesParams{
+			InputFileORURL: inputFile,
+			FireHOLFile:    fireHOLFile,
+			CacheFireHOL:   i > 0,
+			ToCSVFile:      csvFile,
+		})
+		r.NoError(err)
+		r.Equal(1, res.Matches)
+
+		data, err := os.ReadFile(csvFile)
+		r.NoError(err)
+		records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		r.NoError(err)
+		r.Equal([]string{"30", VerdictReview}, records[1][3:])
+	}
+}
+
+func TestSharedRanges(t *testing.T) {
+	r := require.New(t)
+
+	dir := t.TempDir()
+	inputFile := filepath.Join(dir, "ips.txt")
+	threatFile := filepath.Join(dir, "threats.txt")
+	dcFile := filepath.Join(dir, "dc.txt")
+
+	r.NoError(os.WriteFile(inputFile, []byte("10.1.2.3\n"), 0644))
+	r.NoError(os.WriteFile(threatFile, []byte("10.0.0.0/8\n"), 0644))
+	r.NoError(os.WriteFile(dcFile, []byte("10.0.0.0/8\n"), 0644))
+
+	sources := []Source{
+		{Name: "threats", Type: SourceList, Path: threatFile, Category: CategoryThreat, Severity: "critical", Priority: 10},
+		{Name: "dc", Type: SourceList, Path: dcFile, Category: CategoryDatacenter, Severity: "low"},
+	}
+
+	for _, index := range []string{"tree", "flat", "radix"} {
+		for _, match := range MatchModes {
+			csvFile := filepath.Join(t.TempDir(), "matches.csv")
+
+			res, err := Check(CheckAgainstIPRangesParams{
+				InputFileORURL: inputFile,
+				Sources:        sources,
+				Index:          index,
+				Match:          match,
+				ToCSVFile:      csvFile,
+				Scoring:        Scoring{HalfLife: -1},
+			})
+			r.NoError(err)
+			r.Equal(1, res.Matches)
+
+			data, err := os.ReadFile(csvFile)
+			r.NoError(err)
+			records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+			r.NoError(err)
+
+			// Both lists count towards the score, whatever is reported.
+			r.Equal([]string{"92", VerdictBlock}, records[1][3:], index+" "+match)
+
+			if match == "all" {
+				r.Equal("threats | 10.0.0.0 - 10.255.255.255; dc | 10.0.0.0 - 10.255.255.255", records[1][2], index)
+			} else {
+				r.Equal("threats | 10.0.0.0 - 10.255.255.255", records[1][2], index+" "+match)
+			}
+		}
+	}
+}
+
+func float64Ptr(v float64) *float64 {
+	return &v
+}
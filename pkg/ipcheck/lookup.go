@@ -0,0 +1,342 @@
+package ipcheck
+
+import (
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anrid/ipcheck/pkg/radix"
+	"github.com/pkg/errors"
+)
+
+// LookupParams controls which sources are loaded by NewLookup.
+type LookupParams struct {
+	IPRangesCSVFileOrURL string
+	FireHOLFile          string
+	// Sources are loaded in addition to the IP ranges CSV and FireHOL file.
+	Sources []Source
+	// ASNFile is an optional IP to ASN database in the TSV format published
+	// at https://iptoasn.com (e.g. ip2asn-combined.tsv).
+	ASNFile string
+	// Scoring configures the risk score of each result.
+	Scoring Scoring
+}
+
+// LookupMatch is a range or IP from one of the sources matching a lookup.
+type LookupMatch struct {
+	List     string `json:"list"`
+	Category string `json:"category"`
+	// Info holds additional info about a FireHOL list, e.g. its maintainer.
+	Info  string `json:"info,omitempty"`
+	CIDR  string `json:"cidr"`
+	First string `json:"first"`
+	Last  string `json:"last"`
+	// Source is the name of the source the list was loaded from, Path the
+	// file (or URL) it was loaded from and Updated the time it was last
+	// modified, if known.
+	Source   string     `json:"source"`
+	Path     string     `json:"path"`
+	Updated  *time.Time `json:"updated,omitempty"`
+	Severity string     `json:"severity"`
+	Priority int        `json:"priority"`
+}
+
+// LookupResult holds all matches for an IP or CIDR.
+type LookupResult struct {
+	Query   string        `json:"query"`
+	Matches []LookupMatch `json:"matches"`
+	// ASN is set if an ASN database was loaded and contains the IP (or the
+	// first IP of the CIDR).
+	ASN *ASN `json:"asn,omitempty"`
+	// Score is the risk score based on all matches, see Scoring.
+	Score Score `json:"score"`
+}
+
+// Lookup holds all sources in memory for looking up IPs and CIDRs. Unlike
+// the checker it supports both IPv4 and IPv6.
+type Lookup struct {
+	lists []lookupList
+	// Positions of all lists containing each prefix.
+	trie    radix.Trie[[]int32]
+	asns    *asnDB
+	scoring Scoring
+}
+
+type lookupList struct {
+	name    string
+	info    string
+	source  *Source
+	updated *time.Time
+
+	numCIDRs int
+	numIPv4s uint64
+}
+
+// NewLookup loads all configured sources.
+func NewLookup(p LookupParams) (*Lookup, error) {
+	sources, err := legacySources(p.IPRangesCSVFileOrURL, p.FireHOLFile, p.Sources)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.Scoring.Validate(); err != nil {
+		return nil, err
+	}
+
+	l := &Lookup{scoring: p.Scoring.withDefaults()}
+
+	for i := range sources {
+		src := &sources[i]
+		listIDs := make(map[string]int32)
+
+		err := readSource(*src, func(list, info string, updated *time.Time, cidr string) error {
+			id, found := listIDs[list]
+			if !found {
+				id = l.addList(lookupList{name: list, info: info, source: src, updated: updated})
+				listIDs[list] = id
+			}
+			return l.add(cidr, id)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.ASNFile != "" {
+		asns, err := loadASNFile(p.ASNFile)
+		if err != nil {
+			return nil, err
+		}
+		l.asns = asns
+	}
+
+	return l, nil
+}
+
+func (l *Lookup) addList(list lookupList) int32 {
+	l.lists = append(l.lists, list)
+	return int32(len(l.lists) - 1)
+}
+
+// add adds a CIDR or single IP belonging to the given list.
+func (l *Lookup) add(cidr string, list int32) error {
+	p, err := parsePrefix(cidr)
+	if err != nil {
+		return err
+	}
+
+	ids, _ := l.trie.Get(p)
+	l.trie.Insert(p, append(ids, list))
+
+	l.lists[list].numCIDRs++
+	if p.Addr().Is4() {
+		l.lists[list].numIPv4s += 1 << (32 - p.Bits())
+	}
+
+	return nil
+}
+
+// parsePrefix parses a CIDR, or a single IP as a /32 (or /128 for IPv6).
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.ContainsRune(s, '/') {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, errors.Wrapf(err, "invalid IP: %s", s)
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, errors.Wrapf(err, "invalid CIDR: %s", s)
+	}
+	return p.Masked(), nil
+}
+
+// Lookup returns all ranges and IPs containing the given IP, sorted from the
+// broadest to the most specific range. For a CIDR it returns all ranges and
+// IPs overlapping any part of it, sorted by address.
+func (l *Lookup) Lookup(query string) (*LookupResult, error) {
+	p, err := parsePrefix(strings.TrimSpace(query))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &LookupResult{Query: query, Matches: make([]LookupMatch, 0)}
+
+	var matches []radix.Match[[]int32]
+	if p.IsSingleIP() {
+		matches = l.trie.Covering(p.Addr())
+	} else {
+		matches = l.trie.Overlapping(p)
+	}
+
+	var scored []scoredList
+	for _, m := range matches {
+		first, last := prefixRange(m.Prefix)
+
+		for _, id := range m.Payload {
+			list := l.lists[id]
+			scored = append(scored, scoredList{name: list.name, source: list.source, updated: list.updated})
+			res.Matches = append(res.Matches, LookupMatch{
+				List:     list.name,
+				Category: list.source.Category,
+				Info:     list.info,
+				CIDR:     m.Prefix.String(),
+				First:    first.String(),
+				Last:     last.String(),
+				Source:   list.source.Name,
+				Path:     list.source.Path,
+				Updated:  list.updated,
+				Severity: list.source.Severity,
+				Priority: list.source.Priority,
+			})
+		}
+	}
+
+	res.Score = l.scoring.score(scored, time.Now())
+
+	if l.asns != nil {
+		res.ASN = l.asns.lookup(p.Addr())
+	}
+
+	return res, nil
+}
+
+// Filter removes all matches from sources not selected by the filter. The
+// score is left as is, based on all matches.
+func (r *LookupResult) Filter(f MatchFilter) error {
+	if err := f.validate(); err != nil {
+		return err
+	}
+
+	matches := r.Matches[:0]
+	for _, m := range r.Matches {
+		if f.selects(Source{Category: m.Category, Severity: m.Severity}) {
+			matches = append(matches, m)
+		}
+	}
+	r.Matches = matches
+
+	return nil
+}
+
+// Rank sorts the matches by the priority and then severity of their sources,
+// the highest first, keeping the order of matches from equally ranked
+// sources.
+func (r *LookupResult) Rank() {
+	sort.SliceStable(r.Matches, func(i, j int) bool {
+		a, b := r.Matches[i], r.Matches[j]
+		return compareRank(Source{Priority: a.Priority, Severity: a.Severity}, Source{Priority: b.Priority, Severity: b.Severity}) > 0
+	})
+}
+
+// prefixRange returns the first and last address of the prefix.
+func prefixRange(p netip.Prefix) (first, last netip.Addr) {
+	first = p.Masked().Addr()
+
+	b := first.AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	last, _ = netip.AddrFromSlice(b)
+
+	return first, last
+}
+
+// modTime returns the modification time of a local file, or nil for a URL.
+func modTime(fileOrURL string) *time.Time {
+	fi, err := os.Stat(fileOrURL)
+	if err != nil {
+		return nil
+	}
+	t := fi.ModTime()
+	return &t
+}
+
+// ListInfo describes a list (a vendor or FireHOL IP set) loaded by a Lookup.
+type ListInfo struct {
+	Name     string     `json:"name"`
+	Category string     `json:"category"`
+	Info     string     `json:"info,omitempty"`
+	Source   string     `json:"source"`
+	Path     string     `json:"path"`
+	Updated  *time.Time `json:"updated,omitempty"`
+	Severity string     `json:"severity"`
+	Priority int        `json:"priority"`
+	// CIDRs is the number of CIDRs and single IPs in the list, and IPv4s
+	// the number of IPv4 addresses they cover (counting overlaps twice).
+	CIDRs int    `json:"cidrs"`
+	IPv4s uint64 `json:"ipv4s"`
+}
+
+// Lists returns all loaded lists, in the order they were loaded.
+func (l *Lookup) Lists() []ListInfo {
+	res := make([]ListInfo, 0, len(l.lists))
+	for _, list := range l.lists {
+		res = append(res, ListInfo{
+			Name:     list.name,
+			Category: list.source.Category,
+			Info:     list.info,
+			Source:   list.source.Name,
+			Path:     list.source.Path,
+			Updated:  list.updated,
+			Severity: list.source.Severity,
+			Priority: list.source.Priority,
+			CIDRs:    list.numCIDRs,
+			IPv4s:    list.numIPv4s,
+		})
+	}
+	return res
+}
+
+// List returns the list with the given name.
+func (l *Lookup) List(name string) (ListInfo, bool) {
+	for _, list := range l.Lists() {
+		if list.Name == name {
+			return list, true
+		}
+	}
+	return ListInfo{}, false
+}
+
+// Contains returns all ranges and IPs of the named list containing the given
+// IP (or overlapping the given CIDR), see Lookup.
+func (l *Lookup) Contains(list, query string) ([]LookupMatch, error) {
+	if _, found := l.List(list); !found {
+		return nil, errors.Errorf("unknown list: %s", list)
+	}
+
+	res, err := l.Lookup(query)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []LookupMatch
+	for _, m := range res.Matches {
+		if m.List == list {
+			matches = append(matches, m)
+		}
+	}
+
+	return matches, nil
+}
+
+// LookupStats summarizes the data loaded by a Lookup.
+type LookupStats struct {
+	Lists     int `json:"lists"`
+	Prefixes  int `json:"prefixes"`
+	ASNRanges int `json:"asn_ranges"`
+}
+
+// Stats returns the number of lists, distinct prefixes and ASN ranges loaded.
+func (l *Lookup) Stats() LookupStats {
+	st := LookupStats{Lists: len(l.lists), Prefixes: l.trie.Len()}
+	if l.asns != nil {
+		st.ASNRanges = len(l.asns.ranges)
+	}
+	return st
+}
@@ -0,0 +1,241 @@
+package ipcheck
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/pkg/errors"
+)
+
+const defaultReportTopN = 10
+
+// Match categories, i.e. the kind of source a list was loaded from.
+const (
+	CategoryDatacenter = "datacenter"
+	CategoryBlocklist  = "blocklist"
+)
+
+// Report holds aggregate statistics of a scan.
+type Report struct {
+	IPsChecked       int `json:"ips_checked"`
+	UniqueIPsChecked int `json:"unique_ips_checked"`
+	Matches          int `json:"matches"`
+	UniqueMatches    int `json:"unique_matches"`
+	Dupes            int `json:"dupes"`
+	// DatacenterPercent is the percentage of all IPs checked which are
+	// within a datacenter range.
+	DatacenterPercent float64 `json:"datacenter_percent"`
+
+	// ByList, BySource and ByCategory count the IPs found in each list,
+	// source and category, including matches not reported because of the
+	// match mode or filter.
+	ByList     []Count  `json:"by_list"`
+	BySource   []Count  `json:"by_source"`
+	ByCategory []Count  `json:"by_category"`
+	BySubnet   []Count  `json:"by_subnet"`
+	TopIPs     []IPHits `json:"top_ips"`
+	// ByVerdict counts unique matched IPs, see Scoring.
+	ByVerdict []Count `json:"by_verdict"`
+}
+
+// Count is the number of matches for a list, category or subnet.
+type Count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// IPHits summarizes all matches of a single IP.
+type IPHits struct {
+	IP    string   `json:"ip"`
+	Hits  int      `json:"hits"`
+	Lists []string `json:"lists"`
+	// Score and Verdict are the risk score of the IP, see Scoring.
+	Score   float64 `json:"score"`
+	Verdict string  `json:"verdict"`
+
+	FirstLineNumber int    `json:"first_line_number"`
+	FirstLine       string `json:"first_line"`
+	LastLineNumber  int    `json:"last_line_number"`
+	LastLine        string `json:"last_line"`
+}
+
+// listMatch is a list an IP was found in.
+type listMatch struct {
+	list     string
+	category string
+	source   string
+}
+
+// scanStats collects the statistics for a Report while scanning.
+type scanStats struct {
+	ipsChecked       int
+	uniqueIPsChecked int
+	matches          int
+	uniqueMatches    int
+	dupes            int
+	datacenterHits   int
+
+	byList     map[string]int
+	bySource   map[string]int
+	byCategory map[string]int
+	bySubnet   map[string]int
+	byVerdict  map[string]int
+	ips        map[string]*IPHits
+}
+
+func newScanStats() *scanStats {
+	return &scanStats{
+		byList:     make(map[string]int),
+		bySource:   make(map[string]int),
+		byCategory: make(map[string]int),
+		bySubnet:   make(map[string]int),
+		byVerdict:  make(map[string]int),
+		ips:        make(map[string]*IPHits),
+	}
+}
+
+// found records an IP found in the given lists, whether it's reported as a
+// match or not (see Match and Filter).
+func (s *scanStats) found(matches []listMatch) {
+	categories, sources := make(map[string]bool), make(map[string]bool)
+	for _, m := range matches {
+		s.byList[m.list]++
+		categories[m.category] = true
+		sources[m.source] = true
+	}
+	for c := range categories {
+		s.byCategory[c]++
+	}
+	for src := range sources {
+		s.bySource[src]++
+	}
+	if categories[CategoryDatacenter] {
+		s.datacenterHits++
+	}
+}
+
+// add records a match of ip found on the given line, in the given lists.
+func (s *scanStats) add(lineNumber int, line, ip string, matches []listMatch, score Score) {
+	s.bySubnet[iputil.Long2IP(iputil.IP2Long(ip)&^0xff)+"/24"]++
+
+	h, found := s.ips[ip]
+	if !found {
+		h = &IPHits{IP: ip, Score: score.Score, Verdict: score.Verdict, FirstLineNumber: lineNumber, FirstLine: line}
+		s.ips[ip] = h
+		s.byVerdict[score.Verdict]++
+	}
+	h.Hits++
+	h.LastLineNumber, h.LastLine = lineNumber, line
+	for _, m := range matches {
+		if !contains(h.Lists, m.list) {
+			h.Lists = append(h.Lists, m.list)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
+
+// report returns the collected statistics, including the topN most
+// frequently matched IPs and subnets.
+func (s *scanStats) report(topN int) *Report {
+	r := &Report{
+		IPsChecked:       s.ipsChecked,
+		UniqueIPsChecked: s.uniqueIPsChecked,
+		Matches:          s.matches,
+		UniqueMatches:    s.uniqueMatches,
+		Dupes:            s.dupes,
+		ByList:           sortedCounts(s.byList),
+		BySource:         sortedCounts(s.bySource),
+		ByCategory:       sortedCounts(s.byCategory),
+		BySubnet:         sortedCounts(s.bySubnet),
+		ByVerdict:        sortedCounts(s.byVerdict),
+	}
+
+	if len(r.BySubnet) > topN {
+		r.BySubnet = r.BySubnet[:topN]
+	}
+
+	if s.ipsChecked > 0 {
+		r.DatacenterPercent = float64(s.datacenterHits) * 100 / float64(s.ipsChecked)
+	}
+
+	r.TopIPs = make([]IPHits, 0, len(s.ips))
+	for _, h := range s.ips {
+		r.TopIPs = append(r.TopIPs, *h)
+	}
+	sort.Slice(r.TopIPs, func(i, j int) bool {
+		a, b := r.TopIPs[i], r.TopIPs[j]
+		return a.Hits > b.Hits || a.Hits == b.Hits && a.FirstLineNumber < b.FirstLineNumber
+	})
+	if len(r.TopIPs) > topN {
+		r.TopIPs = r.TopIPs[:topN]
+	}
+
+	return r
+}
+
+// sortedCounts returns the counts sorted by count (highest first), then name.
+func sortedCounts(counts map[string]int) []Count {
+	res := make([]Count, 0, len(counts))
+	for name, n := range counts {
+		res = append(res, Count{Name: name, Count: n})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Count > res[j].Count || res[i].Count == res[j].Count && res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// Print writes the report to stdout.
+func (r *Report) Print() {
+	printCounts := func(title string, counts []Count) {
+		if len(counts) == 0 {
+			return
+		}
+		fmt.Printf("\n%s:\n", title)
+		for _, c := range counts {
+			fmt.Printf("  %-40s %8d\n", c.Name, c.Count)
+		}
+	}
+
+	printCounts("Matches by list", r.ByList)
+	printCounts("Matches by source", r.BySource)
+	printCounts("Matches by category", r.ByCategory)
+	printCounts("Top subnets (/24)", r.BySubnet)
+	printCounts("Unique IPs by verdict", r.ByVerdict)
+
+	if len(r.TopIPs) > 0 {
+		fmt.Printf("\nTop IPs:\n")
+		for _, h := range r.TopIPs {
+			fmt.Printf("  %-15s %6d hits | score %5.1f %-6s | lines %d - %d | %s\n", h.IP, h.Hits, h.Score, h.Verdict, h.FirstLineNumber, h.LastLineNumber, strings.Join(h.Lists, ", "))
+		}
+	}
+
+	fmt.Printf("\nTraffic from datacenters: %.1f%% of %d IPs checked\n", r.DatacenterPercent, r.IPsChecked)
+}
+
+// WriteJSONFile writes the report to file as JSON.
+func (r *Report) WriteJSONFile(file string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode report")
+	}
+
+	err = os.WriteFile(file, append(data, '\n'), 0644)
+	if err != nil {
+		return errors.Wrapf(err, "could not write report to file: %s", file)
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package ipcheck
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Verdicts, from the least to the most severe.
+const (
+	VerdictAllow  = "allow"
+	VerdictReview = "review"
+	VerdictBlock  = "block"
+)
+
+// Scoring configures how all lists an IP is found in, regardless of Match and
+// Filter, are combined into a risk score from 0 to 100:
+//
+//   - Every list counts as independent evidence with the weight of its source
+//     (see Source.Weight), so a hit in two lists of weight 50 scores 75,
+//     and in three lists 87.5.
+//   - The weight of a list halves every HalfLife since it was last updated:
+//     the source file date of a FireHOL IP set, or else the modification time
+//     of a local file or the Last-Modified time of a URL. Lists of unknown
+//     age count in full.
+//   - Lists in the datacenter category only count once, with DatacenterWeight.
+//
+// The zero value uses the defaults for all settings.
+type Scoring struct {
+	// SeverityWeights is the weight of sources of each severity, from 0 to
+	// 100 (defaults: info 0, low 10, medium 30, high 60, critical 90).
+	SeverityWeights map[string]float64 `yaml:"severity_weights" json:"severity_weights"`
+	// DatacenterWeight is the weight of being in a datacenter range
+	// (default 20).
+	DatacenterWeight *float64 `yaml:"datacenter_weight" json:"datacenter_weight"`
+	// HalfLife is the age at which a list counts half (default 7 days, a
+	// negative value ignores the age of lists).
+	HalfLife time.Duration `yaml:"half_life" json:"half_life"`
+	// ReviewAt and BlockAt are the scores from which the verdict is review
+	// and block respectively (defaults 30 and 70).
+	ReviewAt *float64 `yaml:"review_at" json:"review_at"`
+	BlockAt  *float64 `yaml:"block_at" json:"block_at"`
+}
+
+var defaultSeverityWeights = map[string]float64{
+	"info":     0,
+	"low":      10,
+	"medium":   30,
+	"high":     60,
+	"critical": 90,
+}
+
+const (
+	defaultDatacenterWeight = 20
+	defaultHalfLife         = 7 * 24 * time.Hour
+	defaultReviewAt         = 30
+	defaultBlockAt          = 70
+)
+
+// Validate returns an error if any of the settings is out of range, with
+// defaults applied to the settings not set.
+func (s Scoring) Validate() error {
+	for severity, w := range s.SeverityWeights {
+		if severityLevel(severity) < 0 {
+			return errors.Errorf("unknown severity %q in severity weights", severity)
+		}
+		if w < 0 || w > 100 {
+			return errors.Errorf("weight of severity %s must be from 0 to 100, got %g", severity, w)
+		}
+	}
+	if s.DatacenterWeight != nil && (*s.DatacenterWeight < 0 || *s.DatacenterWeight > 100) {
+		return errors.Errorf("datacenter weight must be from 0 to 100, got %g", *s.DatacenterWeight)
+	}
+	if d := s.withDefaults(); *d.ReviewAt < 0 || *d.BlockAt < 0 || *d.ReviewAt > *d.BlockAt {
+		return errors.Errorf("invalid score thresholds: review at %g, block at %g", *d.ReviewAt, *d.BlockAt)
+	}
+	return nil
+}
+
+// withDefaults returns the settings with all defaults filled in.
+func (s Scoring) withDefaults() Scoring {
+	weights := make(map[string]float64, len(defaultSeverityWeights))
+	for severity, w := range defaultSeverityWeights {
+		weights[severity] = w
+	}
+	for severity, w := range s.SeverityWeights {
+		weights[severity] = w
+	}
+	s.SeverityWeights = weights
+
+	if s.DatacenterWeight == nil {
+		w := float64(defaultDatacenterWeight)
+		s.DatacenterWeight = &w
+	}
+	if s.HalfLife == 0 {
+		s.HalfLife = defaultHalfLife
+	}
+	if s.ReviewAt == nil {
+		v := float64(defaultReviewAt)
+		s.ReviewAt = &v
+	}
+	if s.BlockAt == nil {
+		v := float64(defaultBlockAt)
+		s.BlockAt = &v
+	}
+
+	return s
+}
+
+// Score is the risk score of an IP.
+type Score struct {
+	// Score is from 0 (no risk) to 100.
+	Score   float64 `json:"score"`
+	Verdict string  `json:"verdict"`
+	// Lists is the number of lists the IP was found in, not counting lists
+	// in the datacenter category, and Datacenter is true if it was found in
+	// any datacenter range.
+	Lists      int  `json:"lists"`
+	Datacenter bool `json:"datacenter"`
+}
+
+// String returns the score and verdict, e.g. "score 45.5, review".
+func (s Score) String() string {
+	return fmt.Sprintf("score %g, %s", s.Score, s.Verdict)
+}
+
+// scoredList is a list an IP was found in, as input to Scoring.score.
+type scoredList struct {
+	name    string
+	source  *Source
+	updated *time.Time
+}
+
+// score returns the score of an IP found in the given lists, which must have
+// been set up with withDefaults.
+func (s Scoring) score(lists []scoredList, now time.Time) Score {
+	var res Score
+	// The probability of the IP not being a risk, given all evidence.
+	benign := 1.0
+	seen := make(map[string]bool)
+
+	for _, l := range lists {
+		if l.source.Category == CategoryDatacenter {
+			if !res.Datacenter {
+				res.Datacenter = true
+				benign *= 1 - *s.DatacenterWeight/100
+			}
+			continue
+		}
+
+		key := l.source.Name + "\x00" + l.name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		res.Lists++
+
+		w := s.SeverityWeights[l.source.Severity]
+		if l.source.Weight != nil {
+			w = *l.source.Weight
+		}
+		if s.HalfLife > 0 && l.updated != nil && now.After(*l.updated) {
+			w *= math.Pow(0.5, float64(now.Sub(*l.updated))/float64(s.HalfLife))
+		}
+
+		benign *= 1 - w/100
+	}
+
+	res.Score = math.Round((1-benign)*1000) / 10
+
+	switch {
+	case res.Score >= *s.BlockAt:
+		res.Verdict = VerdictBlock
+	case res.Score >= *s.ReviewAt:
+		res.Verdict = VerdictReview
+	default:
+		res.Verdict = VerdictAllow
+	}
+
+	return res
+}
@@ -0,0 +1,441 @@
+package ipcheck
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/pkg/errors"
+)
+
+// Source types, i.e. the formats sources can be loaded from.
+const (
+	// SourceRangesCSV is a CSV file in the format
+	// "cidr","hostmin","hostmax","vendor", with one list per vendor.
+	SourceRangesCSV = "ranges-csv"
+	// SourceFireHOL is a firehol.ips file created by firehol.Download, with
+	// one list per FireHOL IP set.
+	SourceFireHOL = "firehol"
+	// SourceList is a plain list of CIDRs, IPs and IP ranges, one per line,
+	// with optional labels and "#" comments (see iputil.ParseListLine).
+	// Labelled entries form a list named after the label, all others a
+	// list named after the source.
+	SourceList = "list"
+	// SourceCloudJSON is a JSON feed of cloud provider IP ranges, e.g.
+	// https://ip-ranges.amazonaws.com/ip-ranges.json, https://www.gstatic.com/ipranges/cloud.json
+	// or an Azure service tags file.
+	SourceCloudJSON = "cloud-json"
+)
+
+// SourceTypes lists all supported source types.
+var SourceTypes = []string{SourceRangesCSV, SourceFireHOL, SourceList, SourceCloudJSON}
+
+// Source is a named source of IP ranges, e.g. a FireHOL file or an internal
+// list of office IPs.
+type Source struct {
+	Name string `yaml:"name" json:"name"`
+	Type string `yaml:"type" json:"type"`
+	// Path is the path (or for all but FireHOL files, the URL) to load the
+	// source from.
+	Path string `yaml:"path" json:"path"`
+	// Category says what kind of source it is, e.g. "datacenter", "threat"
+	// or "internal" (defaults to "datacenter" for ranges CSV files and cloud
+	// JSON feeds, else "blocklist").
+	Category string `yaml:"category" json:"category"`
+	// Severity is one of Severities (defaults to "medium").
+	Severity string `yaml:"severity" json:"severity"`
+	// Priority ranks matches from different sources, the highest first.
+	Priority int `yaml:"priority" json:"priority"`
+	// Weight is the weight of matches from this source in risk scores, from
+	// 0 to 100 (defaults to the weight of its severity, see Scoring).
+	Weight *float64 `yaml:"weight" json:"weight,omitempty"`
+}
+
+// Well-known source categories.
+const (
+	CategoryThreat   = "threat"
+	CategoryInternal = "internal"
+)
+
+const defaultSeverity = "medium"
+
+// Severities lists all severities, from the lowest to the highest.
+var Severities = []string{"info", "low", "medium", "high", "critical"}
+
+// severityLevel returns the position of the severity in Severities, or -1 if
+// it's unknown.
+func severityLevel(severity string) int {
+	for i, s := range Severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// Validate returns an error if the source is incomplete or has an unknown
+// type or severity.
+func (s Source) Validate() error {
+	if s.Name == "" {
+		return errors.New("source has no name")
+	}
+	if s.Path == "" {
+		return errors.Errorf("source %s has no path", s.Name)
+	}
+	if !contains(SourceTypes, s.Type) {
+		return errors.Errorf("source %s has unknown type %q (supported: %s)", s.Name, s.Type, strings.Join(SourceTypes, ", "))
+	}
+	if s.Severity != "" && severityLevel(s.Severity) < 0 {
+		return errors.Errorf("source %s has unknown severity %q (supported: %s)", s.Name, s.Severity, strings.Join(Severities, ", "))
+	}
+	if s.Weight != nil && (*s.Weight < 0 || *s.Weight > 100) {
+		return errors.Errorf("source %s has weight %g, must be from 0 to 100", s.Name, *s.Weight)
+	}
+	return nil
+}
+
+// withDefaults returns the source with its category and severity set.
+func (s Source) withDefaults() Source {
+	if s.Category == "" {
+		s.Category = CategoryBlocklist
+		if s.Type == SourceRangesCSV || s.Type == SourceCloudJSON {
+			s.Category = CategoryDatacenter
+		}
+	}
+	if s.Severity == "" {
+		s.Severity = defaultSeverity
+	}
+	return s
+}
+
+// legacySources returns the sources given by the IP ranges CSV file (or URL)
+// and FireHOL file parameters, followed by all other sources.
+func legacySources(ipRangesCSVFileOrURL, fireHOLFile string, sources []Source) ([]Source, error) {
+	var res []Source
+	if ipRangesCSVFileOrURL != "" {
+		res = append(res, Source{Name: "ip-ranges", Type: SourceRangesCSV, Path: ipRangesCSVFileOrURL})
+	}
+	if fireHOLFile != "" {
+		res = append(res, Source{Name: "firehol", Type: SourceFireHOL, Path: fireHOLFile})
+	}
+	res = append(res, sources...)
+
+	names := make(map[string]bool)
+	for i, s := range res {
+		if err := s.Validate(); err != nil {
+			return nil, err
+		}
+		if names[s.Name] {
+			return nil, errors.Errorf("duplicate source name: %s", s.Name)
+		}
+		names[s.Name] = true
+		res[i] = s.withDefaults()
+	}
+
+	return res, nil
+}
+
+// compareRank returns a positive number if matches from source a rank higher
+// than from source b, a negative one if they rank lower and 0 if they rank
+// the same: by priority, then severity.
+func compareRank(a, b Source) int {
+	if a.Priority != b.Priority {
+		return a.Priority - b.Priority
+	}
+	return severityLevel(a.Severity) - severityLevel(b.Severity)
+}
+
+// MatchFilter selects matches by the source which flagged them. The zero
+// value selects all matches.
+type MatchFilter struct {
+	// Categories only selects matches from sources in these categories.
+	Categories []string
+	// MinSeverity only selects matches from sources with at least this
+	// severity.
+	MinSeverity string
+}
+
+func (f MatchFilter) validate() error {
+	if f.MinSeverity != "" && severityLevel(f.MinSeverity) < 0 {
+		return errors.Errorf("unknown severity %q (supported: %s)", f.MinSeverity, strings.Join(Severities, ", "))
+	}
+	return nil
+}
+
+// selects returns true if matches from the given source are selected.
+func (f MatchFilter) selects(s Source) bool {
+	if len(f.Categories) > 0 && !contains(f.Categories, s.Category) {
+		return false
+	}
+	return severityLevel(s.Severity) >= severityLevel(f.MinSeverity)
+}
+
+// Range is an IP range loaded from one of the range sources, together with
+// the name of the list (or vendor) it belongs to.
+type Range struct {
+	Start uint32
+	End   uint32
+	List  string
+	Info  string
+	// Source and Category are the name and category of the source the range
+	// was loaded from.
+	Source   string
+	Category string
+}
+
+// LoadRanges loads all IPv4 ranges from a ranges CSV file (or URL), an
+// optional FireHOL file and any other sources. Single IPs are returned as
+// ranges containing one IP.
+func LoadRanges(ipRangesCSVFileOrURL, fireHOLFile string, sources ...Source) ([]Range, error) {
+	sources, err := legacySources(ipRangesCSVFileOrURL, fireHOLFile, sources)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []Range
+
+	for _, s := range sources {
+		err := readSource(s, func(list, info string, _ *time.Time, cidr string) error {
+			if isIPv6(cidr) {
+				return nil
+			}
+
+			c, err := iputil.ParseCIDR(cidr)
+			if err != nil {
+				return err
+			}
+			ipr := c.Range()
+
+			ranges = append(ranges, Range{
+				Start:    ipr.Start,
+				End:      ipr.End,
+				List:     list,
+				Info:     info,
+				Source:   s.Name,
+				Category: s.Category,
+			})
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ranges, nil
+}
+
+// readSource reads all CIDRs and IPs from the source, calling forEachEntry
+// with each of them and the name of the list it belongs to (a vendor, a
+// FireHOL IP set or for other sources, the name of the source), any
+// additional info about the list and the time the list was last updated, if
+// known.
+func readSource(s Source, forEachEntry func(list, info string, updated *time.Time, cidr string) error) error {
+	// Download a URL once, and read the local copy.
+	file, updated, err := localFile(s.Path)
+	if err == nil && file != s.Path {
+		defer os.Remove(file)
+	}
+
+	if err == nil {
+		switch s.Type {
+		case SourceRangesCSV:
+			err = readIPRangesCSV(file, func(cidr, vendor string) error {
+				return forEachEntry(vendor, "", updated, cidr)
+			})
+		case SourceFireHOL:
+			var name, info string
+			var listUpdated *time.Time
+			err = readFireHOLFile(file, func(header string) {
+				name, info, listUpdated = splitFireHOLHeader(header)
+				if listUpdated == nil {
+					listUpdated = updated
+				}
+			}, func(entry string) error {
+				return forEachEntry(name, info, listUpdated, entry)
+			})
+		case SourceList:
+			err = readListFile(file, func(label, cidr string) error {
+				if label != "" {
+					return forEachEntry(label, "", updated, cidr)
+				}
+				return forEachEntry(s.Name, "", updated, cidr)
+			})
+		case SourceCloudJSON:
+			err = readCloudJSON(file, func(cidr string) error {
+				return forEachEntry(s.Name, "", updated, cidr)
+			})
+		default:
+			err = errors.Errorf("unknown type %q", s.Type)
+		}
+	}
+	if err != nil {
+		return errors.Wrapf(err, "could not load source %s from %s", s.Name, s.Path)
+	}
+
+	return nil
+}
+
+// readListFile reads a plain list of CIDRs, IPs and IP ranges, one per line,
+// see iputil.ParseListLine. Ranges are split into CIDRs.
+func readListFile(fileOrURL string, forEachEntry func(label, cidr string) error) error {
+	return readFileOrURL(fileOrURL, func(lineNumber int, line string) error {
+		entry, label, _ := iputil.ParseListLine(line)
+		if entry == "" {
+			return nil
+		}
+
+		err := iputil.ExpandListEntry(entry, func(cidr string) error {
+			return forEachEntry(label, cidr)
+		})
+		return errors.Wrapf(err, "line %d", lineNumber)
+	})
+}
+
+// readCloudJSON reads all CIDRs from a JSON feed of cloud provider IP ranges.
+// The feeds of the big providers all differ, so instead of parsing each of
+// them, this walks the whole document and picks up every string which is a
+// CIDR, e.g. "prefixes": [{"ip_prefix": "3.2.34.0/26", ..}] in the AWS feed.
+func readCloudJSON(fileOrURL string, forEachCIDR func(cidr string) error) error {
+	file, _, err := localFile(fileOrURL)
+	if err != nil {
+		return err
+	}
+	if file != fileOrURL {
+		defer os.Remove(file)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "could not read file: %s", file)
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return errors.Wrapf(err, "could not parse JSON in file: %s", file)
+	}
+
+	var walk func(v interface{}) error
+	walk = func(v interface{}) error {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			// Walk the keys in order, for a stable order of ranges.
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if err := walk(v[k]); err != nil {
+					return err
+				}
+			}
+		case []interface{}:
+			for _, e := range v {
+				if err := walk(e); err != nil {
+					return err
+				}
+			}
+		case string:
+			if _, err := netip.ParsePrefix(v); err == nil {
+				return forEachCIDR(v)
+			}
+		}
+		return nil
+	}
+
+	return walk(doc)
+}
+
+// isIPv6 returns true if the IP or CIDR is an IPv6 one. IPv6 ranges are only
+// supported by Lookup, everything else skips them.
+func isIPv6(cidr string) bool {
+	return strings.ContainsRune(cidr, ':')
+}
+
+// readIPRangesCSV reads a ranges CSV file (or URL) in the format
+// "cidr","hostmin","hostmax","vendor" and calls forEachRange for each record.
+func readIPRangesCSV(fileOrURL string, forEachRange func(cidr, vendor string) error) error {
+	return readCSVFileOrURL(fileOrURL, func(recordNumber int, record []string) error {
+		if recordNumber == 1 {
+			// Skip headers.
+			return nil
+		}
+
+		if len(record) < 4 {
+			return errors.Errorf("expected 4 columns in record %d, got %d", recordNumber, len(record))
+		}
+
+		return forEachRange(record[0], record[3])
+	})
+}
+
+// readFireHOLFile reads a merged FireHOL file created by firehol.Download.
+// forEachList is called with the header of each IP set (without the leading
+// "# ") and forEachEntry with every CIDR or IP belonging to that set.
+func readFireHOLFile(file string, forEachList func(header string), forEachEntry func(entry string) error) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return errors.Wrapf(err, "could not open FireHOL DB file: %s", file)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		t := scanner.Text()
+		if len(t) == 0 {
+			continue
+		}
+
+		entry, _, comment := iputil.ParseListLine(t)
+		if entry == "" {
+			if t[0] == '#' {
+				forEachList(comment)
+			}
+			continue
+		}
+
+		err := iputil.ExpandListEntry(entry, forEachEntry)
+		if err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "failed to read line from FireHOL DB file: %s", file)
+	}
+
+	return nil
+}
+
+// splitFireHOLHeader splits an IP set header, e.g.
+// "iblocklist_org_joost | iBlocklist.com | https://www.iblocklist.com/ | updated 2023-04-01T09:09:53Z (4 CIDRs, 0 IPs)",
+// into the name of the IP set, the remaining info and the time the IP set was
+// last updated, if the header has it.
+func splitFireHOLHeader(header string) (name, info string, updated *time.Time) {
+	parts := strings.SplitN(header, " | ", 2)
+	if len(parts) < 2 {
+		return parts[0], "", nil
+	}
+	name, info = parts[0], parts[1]
+
+	const updatedPrefix = " | updated "
+	if i := strings.Index(info, updatedPrefix); i >= 0 {
+		rest := info[i+len(updatedPrefix):]
+		end := strings.IndexByte(rest, ' ')
+		if end < 0 {
+			end = len(rest)
+		}
+		if t, err := time.Parse(time.RFC3339, rest[:end]); err == nil {
+			updated = &t
+			info = info[:i] + rest[end:]
+		}
+	}
+
+	return name, info, updated
+}
@@ -0,0 +1,44 @@
+package iputil
+
+// IPSet is a set of IPv4 addresses, used to count distinct IPs exactly. It's
+// a sparse bitmap using 8 KB for each /16 containing at least one IP, so even
+// an input containing every single IPv4 address needs no more than 512 MB.
+type IPSet struct {
+	blocks map[uint16]*[1024]uint64
+	size   int
+}
+
+// NewIPSet returns an empty IPSet.
+func NewIPSet() *IPSet {
+	return &IPSet{blocks: make(map[uint16]*[1024]uint64)}
+}
+
+// Add adds ip to the set, returning false if it was already in it.
+func (s *IPSet) Add(ip uint32) bool {
+	b, found := s.blocks[uint16(ip>>16)]
+	if !found {
+		b = new([1024]uint64)
+		s.blocks[uint16(ip>>16)] = b
+	}
+
+	word, bit := (ip&0xffff)>>6, uint64(1)<<(ip&63)
+	if b[word]&bit != 0 {
+		return false
+	}
+
+	b[word] |= bit
+	s.size++
+
+	return true
+}
+
+// Contains returns true if ip is in the set.
+func (s *IPSet) Contains(ip uint32) bool {
+	b, found := s.blocks[uint16(ip>>16)]
+	return found && b[(ip&0xffff)>>6]&(uint64(1)<<(ip&63)) != 0
+}
+
+// Len returns the number of distinct IPs in the set.
+func (s *IPSet) Len() int {
+	return s.size
+}
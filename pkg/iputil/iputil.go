@@ -3,7 +3,11 @@ package iputil
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"math/bits"
 	"net"
+	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -26,6 +30,9 @@ func CIDRToIPRange(cidr string) (startIP, endIP string, err error) {
 	if err != nil {
 		return "", "", errors.Wrapf(err, "could not convert CIDR '%s' to IP range", cidr)
 	}
+	if ipv4Net.IP.To4() == nil {
+		return "", "", errors.Errorf("could not convert CIDR '%s' to IP range: not an IPv4 CIDR", cidr)
+	}
 
 	// Convert IPNet struct mask and address to uint32.
 	mask := binary.BigEndian.Uint32(ipv4Net.Mask)
@@ -41,3 +48,172 @@ func CIDRToIPRange(cidr string) (startIP, endIP string, err error) {
 
 	return
 }
+
+// IPRange is an inclusive range of IPv4 addresses.
+type IPRange struct {
+	Start uint32
+	End   uint32
+}
+
+// CIDR is an IPv4 network given as its first IP and prefix length.
+type CIDR struct {
+	IP     uint32
+	Prefix int
+}
+
+// ParseCIDR parses a CIDR, e.g. "10.0.0.0/8". A plain IP is parsed as a /32.
+func ParseCIDR(cidr string) (CIDR, error) {
+	if !strings.ContainsRune(cidr, '/') {
+		ip := net.ParseIP(cidr).To4()
+		if ip == nil {
+			return CIDR{}, errors.Errorf("invalid IP: %s", cidr)
+		}
+		return CIDR{IP: binary.BigEndian.Uint32(ip), Prefix: 32}, nil
+	}
+
+	_, ipv4Net, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return CIDR{}, errors.Wrapf(err, "could not parse CIDR '%s'", cidr)
+	}
+
+	ones, bits := ipv4Net.Mask.Size()
+	if bits != 32 {
+		return CIDR{}, errors.Errorf("not an IPv4 CIDR: %s", cidr)
+	}
+
+	return CIDR{IP: binary.BigEndian.Uint32(ipv4Net.IP.To4()), Prefix: ones}, nil
+}
+
+// Range returns the first and last IP of the network.
+func (c CIDR) Range() IPRange {
+	size := uint64(1) << (32 - c.Prefix)
+	return IPRange{Start: c.IP, End: uint32(uint64(c.IP) + size - 1)}
+}
+
+// String returns the CIDR in its usual notation, or just the IP for a /32.
+func (c CIDR) String() string {
+	if c.Prefix == 32 {
+		return Long2IP(c.IP)
+	}
+	return fmt.Sprintf("%s/%d", Long2IP(c.IP), c.Prefix)
+}
+
+// RangeToCIDRs returns the smallest list of CIDRs exactly covering the IP
+// range [start, end], in ascending order.
+func RangeToCIDRs(start, end uint32) []CIDR {
+	var cidrs []CIDR
+
+	for s := uint64(start); s <= uint64(end); {
+		// Largest block aligned on s ..
+		prefix := 0
+		if s != 0 {
+			prefix = 32 - bits.TrailingZeros32(uint32(s))
+		}
+		// .. that doesn't go past end.
+		for s+(uint64(1)<<(32-prefix))-1 > uint64(end) {
+			prefix++
+		}
+
+		cidrs = append(cidrs, CIDR{IP: uint32(s), Prefix: prefix})
+		s += uint64(1) << (32 - prefix)
+	}
+
+	return cidrs
+}
+
+// IPRangeToCIDRs is like RangeToCIDRs, but takes and returns strings.
+func IPRangeToCIDRs(startIP, endIP string) ([]string, error) {
+	start, end := IP2Long(startIP), IP2Long(endIP)
+	if start > end {
+		return nil, errors.Errorf("invalid ip range: range max before min [%s - %s]", startIP, endIP)
+	}
+
+	var cidrs []string
+	for _, c := range RangeToCIDRs(start, end) {
+		cidrs = append(cidrs, c.String())
+	}
+
+	return cidrs, nil
+}
+
+// MergeRanges merges all overlapping and adjacent ranges, returning the
+// resulting disjoint ranges in ascending order.
+func MergeRanges(ranges []IPRange) []IPRange {
+	sorted := make([]IPRange, len(ranges))
+	copy(sorted, ranges)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var merged []IPRange
+
+	for _, r := range sorted {
+		if n := len(merged); n > 0 && uint64(r.Start) <= uint64(merged[n-1].End)+1 {
+			if r.End > merged[n-1].End {
+				merged[n-1].End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	return merged
+}
+
+// RangesToCIDRs merges the given ranges and returns the smallest list of
+// CIDRs covering them, in ascending order.
+func RangesToCIDRs(ranges []IPRange) []CIDR {
+	var cidrs []CIDR
+	for _, r := range MergeRanges(ranges) {
+		cidrs = append(cidrs, RangeToCIDRs(r.Start, r.End)...)
+	}
+	return cidrs
+}
+
+// AggregateCIDRs collapses overlapping and adjacent CIDRs into the smallest
+// set of CIDRs covering exactly the same addresses.
+func AggregateCIDRs(cidrs []CIDR) []CIDR {
+	ranges := make([]IPRange, 0, len(cidrs))
+	for _, c := range cidrs {
+		ranges = append(ranges, c.Range())
+	}
+	return RangesToCIDRs(ranges)
+}
+
+// SubtractRanges returns all IPs covered by ranges but not by remove, as
+// disjoint ranges in ascending order.
+func SubtractRanges(ranges, remove []IPRange) []IPRange {
+	remove = MergeRanges(remove)
+
+	var res []IPRange
+	var j int
+
+	for _, r := range MergeRanges(ranges) {
+		start := uint64(r.Start)
+
+		// Skip everything removed before this range.
+		for j < len(remove) && remove[j].End < r.Start {
+			j++
+		}
+
+		for k := j; k < len(remove) && remove[k].Start <= r.End; k++ {
+			if uint64(remove[k].Start) > start {
+				res = append(res, IPRange{Start: uint32(start), End: remove[k].Start - 1})
+			}
+			start = uint64(remove[k].End) + 1
+		}
+
+		if start <= uint64(r.End) {
+			res = append(res, IPRange{Start: uint32(start), End: r.End})
+		}
+	}
+
+	return res
+}
+
+// CountIPs returns the number of IPs covered by the ranges, counting IPs
+// covered by more than one range only once.
+func CountIPs(ranges []IPRange) (n uint64) {
+	for _, r := range MergeRanges(ranges) {
+		n += uint64(r.End) - uint64(r.Start) + 1
+	}
+	return n
+}
@@ -0,0 +1,248 @@
+package iputil
+
+import (
+	"math/rand"
+	"testing"
+	"testing/quick"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRangeToCIDRs(t *testing.T) {
+	r := require.New(t)
+
+	cidrs, err := IPRangeToCIDRs("192.168.0.1", "192.168.0.6")
+	r.NoError(err)
+	r.Equal([]string{"192.168.0.1", "192.168.0.2/31", "192.168.0.4/31", "192.168.0.6"}, cidrs)
+
+	cidrs, err = IPRangeToCIDRs("0.0.0.0", "255.255.255.255")
+	r.NoError(err)
+	r.Equal([]string{"0.0.0.0/0"}, cidrs)
+
+	_, err = IPRangeToCIDRs("10.0.0.2", "10.0.0.1")
+	r.Error(err)
+}
+
+// The CIDRs returned for a range must be aligned, cover the range exactly
+// (no address gained or lost) and be minimal, i.e. no two neighbouring CIDRs
+// could be merged into a bigger one.
+func TestRangeToCIDRsProperties(t *testing.T) {
+	f := func(a, b uint32) bool {
+		start, end := a, b
+		if start > end {
+			start, end = end, start
+		}
+
+		cidrs := RangeToCIDRs(start, end)
+		next := uint64(start)
+
+		for i, c := range cidrs {
+			ipr := c.Range()
+			if uint64(ipr.Start) != next || ipr.End < ipr.Start {
+				return false
+			}
+			if c.Prefix < 32 && c.IP&(uint32(1)<<(32-c.Prefix)-1) != 0 {
+				// Not aligned.
+				return false
+			}
+			if i > 0 && cidrs[i-1].Prefix == c.Prefix && cidrs[i-1].IP>>(33-c.Prefix) == c.IP>>(33-c.Prefix) {
+				// Siblings, could have been merged.
+				return false
+			}
+			next = uint64(ipr.End) + 1
+		}
+
+		return next == uint64(end)+1
+	}
+
+	require.NoError(t, quick.Check(f, &quick.Config{MaxCount: 10000}))
+}
+
+// Aggregating random CIDRs in a small address space must match exactly the
+// same addresses as the input.
+func TestAggregateCIDRsProperties(t *testing.T) {
+	const base = uint32(10 << 24)
+	const size = 1 << 12
+
+	f := func(seed int64) bool {
+		rnd := rand.New(rand.NewSource(seed))
+
+		var cidrs []CIDR
+		var want [size]bool
+
+		for i := rnd.Intn(20); i >= 0; i-- {
+			prefix := 20 + rnd.Intn(13)
+			ip := (base + uint32(rnd.Intn(size))) &^ (uint32(1)<<(32-prefix) - 1)
+			c := CIDR{IP: ip, Prefix: prefix}
+			cidrs = append(cidrs, c)
+
+			ipr := c.Range()
+			for n := ipr.Start; n <= ipr.End; n++ {
+				want[n-base] = true
+			}
+		}
+
+		aggregated := AggregateCIDRs(cidrs)
+		if len(aggregated) > len(cidrs) {
+			return false
+		}
+
+		var got [size]bool
+		for i, c := range aggregated {
+			ipr := c.Range()
+			if i > 0 && ipr.Start <= aggregated[i-1].Range().End {
+				// Overlapping or not sorted.
+				return false
+			}
+			for n := ipr.Start; n <= ipr.End; n++ {
+				got[n-base] = true
+			}
+		}
+
+		return got == want
+	}
+
+	require.NoError(t, quick.Check(f, &quick.Config{MaxCount: 2000}))
+}
+
+func TestParseCIDR(t *testing.T) {
+	r := require.New(t)
+
+	c, err := ParseCIDR("34.64.161.255/19")
+	r.NoError(err)
+	r.Equal("34.64.160.0/19", c.String())
+	r.Equal(IPRange{Start: IP2Long("34.64.160.0"), End: IP2Long("34.64.191.255")}, c.Range())
+
+	c, err = ParseCIDR("4.4.4.4")
+	r.NoError(err)
+	r.Equal(CIDR{IP: IP2Long("4.4.4.4"), Prefix: 32}, c)
+
+	_, err = ParseCIDR("2001:db8::/32")
+	r.Error(err)
+}
+
+func TestIPSet(t *testing.T) {
+	f := func(ips []uint32, probes []uint32) bool {
+		set := NewIPSet()
+		want := make(map[uint32]bool)
+
+		for _, ip := range ips {
+			// Also add some neighbours, to get IPs sharing bitmap words.
+			for _, ip := range []uint32{ip, ip ^ 1, ip ^ 63} {
+				if set.Add(ip) == want[ip] {
+					return false
+				}
+				want[ip] = true
+			}
+		}
+
+		for _, ip := range append(probes, ips...) {
+			if set.Contains(ip) != want[ip] {
+				return false
+			}
+		}
+
+		return set.Len() == len(want)
+	}
+
+	require.NoError(t, quick.Check(f, nil))
+}
+
+func TestSubtractRanges(t *testing.T) {
+	// Ranges within 0-255, compared against a bitmap of all IPs.
+	toRanges := func(bounds []uint8) []IPRange {
+		var ranges []IPRange
+		for i := 0; i+1 < len(bounds); i += 2 {
+			start, end := uint32(bounds[i]), uint32(bounds[i+1])
+			if start > end {
+				start, end = end, start
+			}
+			ranges = append(ranges, IPRange{Start: start, End: end})
+		}
+		return ranges
+	}
+	toBitmap := func(ranges []IPRange) (b [256]bool) {
+		for _, r := range ranges {
+			for ip := r.Start; ip <= r.End; ip++ {
+				b[ip] = true
+			}
+		}
+		return b
+	}
+
+	f := func(a, b []uint8) bool {
+		ranges, remove := toRanges(a), toRanges(b)
+		res := SubtractRanges(ranges, remove)
+
+		want, have, removed := toBitmap(res), toBitmap(ranges), toBitmap(remove)
+		for ip := range want {
+			if want[ip] != (have[ip] && !removed[ip]) {
+				return false
+			}
+		}
+
+		var n uint64
+		for _, ip := range have {
+			if ip {
+				n++
+			}
+		}
+
+		return len(MergeRanges(res)) == len(res) && CountIPs(ranges) == n
+	}
+
+	require.NoError(t, quick.Check(f, &quick.Config{MaxCount: 2000}))
+}
+
+func TestParseListLine(t *testing.T) {
+	r := require.New(t)
+
+	for _, tc := range []struct{ line, entry, label, comment string }{
+		{"", "", "", ""},
+		{"   ", "", "", ""},
+		{"# Maintainer : FireHOL", "", "", "Maintainer : FireHOL"},
+		{"1.2.3.4", "1.2.3.4", "", ""},
+		{"  1.2.3.0/24\t", "1.2.3.0/24", "", ""},
+		{"1.2.3.0/24 office # HQ", "1.2.3.0/24", "office", "HQ"},
+		{"1.2.3.4-1.2.3.9\tpartner vpn", "1.2.3.4-1.2.3.9", "partner vpn", ""},
+		{"2600:1f00::/24#aws", "2600:1f00::/24", "", "aws"},
+		// Ranges may have spaces around the '-'.
+		{"10.0.0.1 - 10.0.0.9 vpn # VPN", "10.0.0.1-10.0.0.9", "vpn", "VPN"},
+		{"10.0.0.1 -10.0.0.9", "10.0.0.1-10.0.0.9", "", ""},
+		{"10.0.0.1-\t10.0.0.9  vpn", "10.0.0.1-10.0.0.9", "vpn", ""},
+		{"10.0.0.1 -", "10.0.0.1-", "", ""},
+	} {
+		entry, label, comment := ParseListLine(tc.line)
+		r.Equal([]string{tc.entry, tc.label, tc.comment}, []string{entry, label, comment}, tc.line)
+	}
+
+	expand := func(entry string) ([]string, error) {
+		var cidrs []string
+		err := ExpandListEntry(entry, func(cidr string) error {
+			cidrs = append(cidrs, cidr)
+			return nil
+		})
+		return cidrs, err
+	}
+
+	cidrs, err := expand("1.2.3.0/24")
+	r.NoError(err)
+	r.Equal([]string{"1.2.3.0/24"}, cidrs)
+
+	cidrs, err = expand("1.2.3.4-1.2.3.9")
+	r.NoError(err)
+	r.Equal([]string{"1.2.3.4/30", "1.2.3.8/31"}, cidrs)
+
+	cidrs, err = expand("1.2.3.4-1.2.3.4")
+	r.NoError(err)
+	r.Equal([]string{"1.2.3.4"}, cidrs)
+
+	cidrs, err = expand("2600:1f00::1")
+	r.NoError(err)
+	r.Equal([]string{"2600:1f00::1"}, cidrs)
+
+	for _, bad := range []string{"1.2.3.9-1.2.3.4", "1.2.3.4-x", "2600::1-2600::2", "1.2.3.4-", "foo", "1.2.3.256", "1.2.3.0/33"} {
+		_, err := expand(bad)
+		r.Error(err, bad)
+	}
+}
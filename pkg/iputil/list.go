@@ -0,0 +1,78 @@
+package iputil
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParseListLine parses a line of a plain list of IPs, like a FireHOL IP set:
+// a CIDR, IP or IPv4 range (a.b.c.d-e.f.g.h, with or without spaces around
+// the '-'), optionally followed by a label and a comment starting with '#',
+// e.g.
+//
+//	10.1.0.0 - 10.1.3.255  office-nyc  # New York office
+//
+// entry is empty for blank lines and lines only holding a comment, and
+// comment is the text after the '#', if any. Ranges are returned without
+// spaces, e.g. "10.1.0.0-10.1.3.255".
+func ParseListLine(line string) (entry, label, comment string) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line, comment = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	entry, label = cutSpace(strings.TrimSpace(line))
+
+	// Join the end of a range with spaces around the '-'.
+	switch {
+	case strings.HasPrefix(label, "-"):
+		var end string
+		end, label = cutSpace(strings.TrimSpace(label[1:]))
+		entry += "-" + end
+	case strings.HasSuffix(entry, "-") && label != "":
+		var end string
+		end, label = cutSpace(label)
+		entry += end
+	}
+
+	return entry, label, comment
+}
+
+// cutSpace splits s at the first space or tab, trimming the remainder.
+func cutSpace(s string) (before, after string) {
+	if i := strings.IndexAny(s, " \t"); i >= 0 {
+		return s[:i], strings.TrimSpace(s[i+1:])
+	}
+	return s, ""
+}
+
+// ExpandListEntry calls forEachCIDR with the entry if it's a CIDR or IP
+// (IPv4 or IPv6), or with the smallest list of CIDRs covering it if it's an
+// IPv4 range. Returns an error for anything else.
+func ExpandListEntry(entry string, forEachCIDR func(cidr string) error) error {
+	i := strings.IndexByte(entry, '-')
+	if i < 0 {
+		if _, _, err := net.ParseCIDR(entry); err != nil && net.ParseIP(entry) == nil {
+			return errors.Errorf("invalid ip or cidr: %s", entry)
+		}
+		return forEachCIDR(entry)
+	}
+
+	startIP, endIP := strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+	if net.ParseIP(startIP).To4() == nil || net.ParseIP(endIP).To4() == nil {
+		return errors.Errorf("invalid ip range: %s", entry)
+	}
+
+	cidrs, err := IPRangeToCIDRs(startIP, endIP)
+	if err != nil {
+		return err
+	}
+	for _, c := range cidrs {
+		if err := forEachCIDR(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+package radix
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/anrid/ipcheck/pkg/interval"
+	"github.com/anrid/ipcheck/pkg/iputil"
+)
+
+var _ interval.Index[any] = (*Index[any])(nil)
+
+// Index is an immutable interval.Index backed by a Trie, so that the checker
+// can use it in place of the interval tree. Intervals which aren't CIDRs are
+// split into CIDRs, each pointing back to the original interval.
+type Index[T any] struct {
+	trie    Trie[[]int32]
+	results []interval.Result[T]
+}
+
+// NewIndex returns a new Index containing all entries. As with
+// interval.Build, the last entry wins if the same interval occurs more than
+// once.
+func NewIndex[T any](entries []interval.Entry[T]) *Index[T] {
+	idx := &Index[T]{results: interval.Build(entries).InOrder()}
+
+	for i, r := range idx.results {
+		for _, c := range iputil.RangeToCIDRs(r.Interval.Start(), r.Interval.Stop()) {
+			p := netip.PrefixFrom(addrFrom4(c.IP), c.Prefix)
+
+			ids, _ := idx.trie.Get(p)
+			idx.trie.Insert(p, append(ids, int32(i)))
+		}
+	}
+
+	return idx
+}
+
+func addrFrom4(ip uint32) netip.Addr {
+	return netip.AddrFrom4([4]byte{byte(ip >> 24), byte(ip >> 16), byte(ip >> 8), byte(ip)})
+}
+
+// Len returns the number of intervals in the index.
+func (idx *Index[T]) Len() int {
+	return len(idx.results)
+}
+
+// FindFirstOverlapping returns the payload of the lowest interval that
+// overlaps with the passed key. Returns an ErrNotFound if no overlapping
+// interval is found.
+func (idx *Index[T]) FindFirstOverlapping(key interval.Interval) (interval.Result[T], error) {
+	first := int32(-1)
+
+	idx.overlapping(key.Start(), key.Stop(), func(ids []int32) {
+		for _, id := range ids {
+			if first < 0 || id < first {
+				first = id
+			}
+		}
+	})
+
+	if first < 0 {
+		return interval.Result[T]{}, interval.ErrNotFound(fmt.Sprintf("no interval found for %q", key))
+	}
+
+	return idx.results[first], nil
+}
+
+// FindAllOverlapping returns a slice of Result with all intervals overlapping
+// the given interval key, sorted by interval. Returns an ErrNotFound if no
+// overlapping interval is found.
+func (idx *Index[T]) FindAllOverlapping(key interval.Interval) ([]interval.Result[T], error) {
+	res := idx.lookup(key.Start(), key.Stop())
+	if len(res) == 0 {
+		return nil, interval.ErrNotFound(fmt.Sprintf("no interval found for %q", key))
+	}
+	return res, nil
+}
+
+// LookupBatch returns all intervals containing each of the given IPs, see
+// interval.Tree.LookupBatch.
+func (idx *Index[T]) LookupBatch(ips []uint32) [][]interval.Result[T] {
+	res := make([][]interval.Result[T], len(ips))
+	for i, ip := range ips {
+		res[i] = idx.lookup(ip, ip)
+	}
+	return res
+}
+
+func (idx *Index[T]) lookup(low, high uint32) []interval.Result[T] {
+	var ids []int32
+
+	idx.overlapping(low, high, func(set []int32) {
+		ids = append(ids, set...)
+	})
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	res := make([]interval.Result[T], 0, len(ids))
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+		res = append(res, idx.results[id])
+	}
+
+	return res
+}
+
+// overlapping calls fn for all IPv4 prefixes overlapping the range low - high.
+func (idx *Index[T]) overlapping(low, high uint32, fn func(ids []int32)) {
+	var visit func(n *node[[]int32])
+
+	visit = func(n *node[[]int32]) {
+		if n == nil {
+			return
+		}
+
+		start := n.key.hi >> 32
+		end := start | (1<<(32-n.bits) - 1)
+		if start > uint64(high) || end < uint64(low) {
+			return
+		}
+
+		if n.set {
+			fn(n.payload)
+		}
+		visit(n.children[0])
+		visit(n.children[1])
+	}
+
+	visit(idx.trie.roots[0])
+}
@@ -0,0 +1,288 @@
+// Package radix implements a compressed binary (Patricia) trie of IPv4 and
+// IPv6 prefixes. Unlike the interval tree it works on CIDRs natively, which is
+// what all our sources are made of, and supports longest prefix matches as
+// well as finding all prefixes covering an IP.
+package radix
+
+import (
+	"math/bits"
+	"net/netip"
+)
+
+// Match is a prefix stored in the trie together with its payload.
+type Match[T any] struct {
+	Prefix  netip.Prefix
+	Payload T
+}
+
+// Trie stores payloads by IPv4 and IPv6 prefix. The zero value is an empty
+// trie ready to use. A Trie is safe for concurrent reads, but writes must not
+// run concurrently with any other access.
+type Trie[T any] struct {
+	roots [2]*node[T] // IPv4 and IPv6
+	size  int
+}
+
+type node[T any] struct {
+	key      key
+	bits     int
+	children [2]*node[T]
+
+	// set is true if a prefix is stored at this node, as opposed to a node
+	// which only joins two branches.
+	set     bool
+	prefix  netip.Prefix
+	payload T
+}
+
+// key holds the bits of an address, left aligned, i.e. an IPv4 address
+// occupies the top 32 bits of hi.
+type key struct {
+	hi, lo uint64
+}
+
+func addrKey(addr netip.Addr) (k key, family int, maxBits int) {
+	if addr.Is4() {
+		a := addr.As4()
+		return key{hi: uint64(a[0])<<56 | uint64(a[1])<<48 | uint64(a[2])<<40 | uint64(a[3])<<32}, 0, 32
+	}
+
+	a := addr.As16()
+	for i := 0; i < 8; i++ {
+		k.hi = k.hi<<8 | uint64(a[i])
+		k.lo = k.lo<<8 | uint64(a[i+8])
+	}
+	return k, 1, 128
+}
+
+// bit returns bit i of the key, counting from the most significant one.
+func (k key) bit(i int) int {
+	if i < 64 {
+		return int(k.hi>>(63-i)) & 1
+	}
+	return int(k.lo>>(127-i)) & 1
+}
+
+// mask clears all but the first n bits of the key.
+func (k key) mask(n int) key {
+	switch {
+	case n == 0:
+		return key{}
+	case n < 64:
+		return key{hi: k.hi &^ (1<<(64-n) - 1)}
+	case n == 64:
+		return key{hi: k.hi}
+	case n < 128:
+		return key{hi: k.hi, lo: k.lo &^ (1<<(128-n) - 1)}
+	}
+	return k
+}
+
+// commonBits returns the number of leading bits a and b have in common, up
+// to max.
+func commonBits(a, b key, max int) int {
+	n := bits.LeadingZeros64(a.hi ^ b.hi)
+	if n == 64 {
+		n += bits.LeadingZeros64(a.lo ^ b.lo)
+	}
+	if n > max {
+		return max
+	}
+	return n
+}
+
+// normalize unmaps IPv4-mapped IPv6 prefixes and clears the host bits.
+func normalize(p netip.Prefix) netip.Prefix {
+	if addr := p.Addr(); addr.Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(addr.Unmap(), p.Bits()-96)
+	}
+	return p.Masked()
+}
+
+// Len returns the number of prefixes in the trie.
+func (t *Trie[T]) Len() int {
+	return t.size
+}
+
+// Insert stores payload for the prefix p, replacing the payload of p if it's
+// already in the trie. Invalid prefixes are ignored.
+func (t *Trie[T]) Insert(p netip.Prefix, payload T) {
+	if !p.IsValid() {
+		return
+	}
+	p = normalize(p)
+
+	k, family, _ := addrKey(p.Addr())
+	leaf := &node[T]{key: k, bits: p.Bits(), set: true, prefix: p, payload: payload}
+
+	link := &t.roots[family]
+	for {
+		n := *link
+		if n == nil {
+			*link = leaf
+			t.size++
+			return
+		}
+
+		common := commonBits(n.key, k, minInt(n.bits, leaf.bits))
+
+		if common == n.bits {
+			if n.bits == leaf.bits {
+				if !n.set {
+					t.size++
+				}
+				n.set, n.prefix, n.payload = true, p, payload
+				return
+			}
+			link = &n.children[k.bit(n.bits)]
+			continue
+		}
+
+		// The new prefix branches off somewhere above n.
+		if common == leaf.bits {
+			// The new prefix covers n.
+			leaf.children[n.key.bit(common)] = n
+			*link = leaf
+		} else {
+			glue := &node[T]{key: k.mask(common), bits: common}
+			glue.children[k.bit(common)] = leaf
+			glue.children[n.key.bit(common)] = n
+			*link = glue
+		}
+		t.size++
+		return
+	}
+}
+
+// Get returns the payload stored for exactly the prefix p.
+func (t *Trie[T]) Get(p netip.Prefix) (T, bool) {
+	var zero T
+	if !p.IsValid() {
+		return zero, false
+	}
+	p = normalize(p)
+
+	k, family, _ := addrKey(p.Addr())
+	for n := t.roots[family]; n != nil && n.bits <= p.Bits(); n = n.children[k.bit(n.bits)] {
+		if commonBits(n.key, k, n.bits) < n.bits {
+			break
+		}
+		if n.bits == p.Bits() {
+			if n.set {
+				return n.payload, true
+			}
+			break
+		}
+	}
+
+	return zero, false
+}
+
+// LongestPrefix returns the most specific prefix containing addr.
+func (t *Trie[T]) LongestPrefix(addr netip.Addr) (Match[T], bool) {
+	var best *node[T]
+
+	t.covering(addr, func(n *node[T]) {
+		best = n
+	})
+
+	if best == nil {
+		return Match[T]{}, false
+	}
+	return Match[T]{Prefix: best.prefix, Payload: best.payload}, true
+}
+
+// Covering returns all prefixes containing addr, from the broadest to the
+// most specific one.
+func (t *Trie[T]) Covering(addr netip.Addr) []Match[T] {
+	var res []Match[T]
+
+	t.covering(addr, func(n *node[T]) {
+		res = append(res, Match[T]{Prefix: n.prefix, Payload: n.payload})
+	})
+
+	return res
+}
+
+func (t *Trie[T]) covering(addr netip.Addr, fn func(n *node[T])) {
+	if !addr.IsValid() {
+		return
+	}
+
+	k, family, maxBits := addrKey(addr.Unmap())
+
+	for n := t.roots[family]; n != nil; n = n.children[k.bit(n.bits)] {
+		if commonBits(n.key, k, n.bits) < n.bits {
+			return
+		}
+		if n.set {
+			fn(n)
+		}
+		if n.bits == maxBits {
+			return
+		}
+	}
+}
+
+// Overlapping returns all prefixes overlapping p, i.e. all prefixes
+// containing p as well as all prefixes within p, sorted by address and, for
+// the same address, from the broadest to the most specific one.
+func (t *Trie[T]) Overlapping(p netip.Prefix) []Match[T] {
+	if !p.IsValid() {
+		return nil
+	}
+	p = normalize(p)
+
+	var res []Match[T]
+	k, family, _ := addrKey(p.Addr())
+
+	for n := t.roots[family]; n != nil; n = n.children[k.bit(n.bits)] {
+		if commonBits(n.key, k, minInt(n.bits, p.Bits())) < minInt(n.bits, p.Bits()) {
+			break
+		}
+		if n.bits >= p.Bits() {
+			// Everything below n is within p.
+			walk(n, func(n *node[T]) bool {
+				res = append(res, Match[T]{Prefix: n.prefix, Payload: n.payload})
+				return true
+			})
+			break
+		}
+		if n.set {
+			res = append(res, Match[T]{Prefix: n.prefix, Payload: n.payload})
+		}
+	}
+
+	return res
+}
+
+// Walk calls fn for all prefixes in the trie, IPv4 before IPv6, sorted by
+// address and, for the same address, from the broadest to the most specific
+// one. Stops as soon as fn returns false.
+func (t *Trie[T]) Walk(fn func(m Match[T]) bool) {
+	for _, root := range t.roots {
+		cont := walk(root, func(n *node[T]) bool {
+			return fn(Match[T]{Prefix: n.prefix, Payload: n.payload})
+		})
+		if !cont {
+			return
+		}
+	}
+}
+
+func walk[T any](n *node[T], fn func(n *node[T]) bool) bool {
+	if n == nil {
+		return true
+	}
+	if n.set && !fn(n) {
+		return false
+	}
+	return walk(n.children[0], fn) && walk(n.children[1], fn)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
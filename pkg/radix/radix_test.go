@@ -0,0 +1,159 @@
+package radix
+
+import (
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"github.com/anrid/ipcheck/pkg/interval"
+	"github.com/anrid/ipcheck/pkg/iputil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrie(t *testing.T) {
+	r := require.New(t)
+
+	var trie Trie[string]
+	for _, c := range []struct{ prefix, payload string }{
+		{"34.64.0.0/10", "blocklist"},
+		{"34.64.160.0/19", "GCP"},
+		{"34.64.161.7/24", "subnet"}, // Host bits are ignored.
+		{"0.0.0.0/0", "all"},
+		{"2600:1900::/28", "GCP v6"},
+		{"2600:1900:4000::/40", "GCP v6 region"},
+		{"::ffff:1.2.3.0/120", "mapped"},
+	} {
+		trie.Insert(netip.MustParsePrefix(c.prefix), c.payload)
+	}
+	r.Equal(7, trie.Len())
+
+	// Replace an existing prefix.
+	trie.Insert(netip.MustParsePrefix("34.64.160.0/19"), "Google Cloud")
+	r.Equal(7, trie.Len())
+
+	payload, found := trie.Get(netip.MustParsePrefix("34.64.161.0/24"))
+	r.True(found)
+	r.Equal("subnet", payload)
+	_, found = trie.Get(netip.MustParsePrefix("34.64.0.0/11"))
+	r.False(found)
+
+	for _, c := range []struct {
+		ip       string
+		covering []string
+	}{
+		{"34.64.161.255", []string{"all", "blocklist", "Google Cloud", "subnet"}},
+		{"34.64.162.1", []string{"all", "blocklist", "Google Cloud"}},
+		{"34.127.255.255", []string{"all", "blocklist"}},
+		{"8.8.8.8", []string{"all"}},
+		{"1.2.3.4", []string{"all", "mapped"}},
+		{"::ffff:34.64.161.1", []string{"all", "blocklist", "Google Cloud", "subnet"}},
+		{"2600:1900:4000::1", []string{"GCP v6", "GCP v6 region"}},
+		{"2600:1900:5000::1", []string{"GCP v6"}},
+		{"2001:db8::1", nil},
+	} {
+		addr := netip.MustParseAddr(c.ip)
+
+		var payloads []string
+		for _, m := range trie.Covering(addr) {
+			r.True(m.Prefix.Contains(addr.Unmap()), c.ip)
+			payloads = append(payloads, m.Payload)
+		}
+		r.Equal(c.covering, payloads, c.ip)
+
+		longest, found := trie.LongestPrefix(addr)
+		r.Equal(len(c.covering) > 0, found, c.ip)
+		if found {
+			r.Equal(c.covering[len(c.covering)-1], longest.Payload, c.ip)
+		}
+	}
+
+	var overlapping []string
+	for _, m := range trie.Overlapping(netip.MustParsePrefix("34.64.128.0/17")) {
+		overlapping = append(overlapping, m.Payload)
+	}
+	r.Equal([]string{"all", "blocklist", "Google Cloud", "subnet"}, overlapping)
+
+	var all []string
+	trie.Walk(func(m Match[string]) bool {
+		all = append(all, m.Prefix.String())
+		return true
+	})
+	r.Equal([]string{
+		"0.0.0.0/0", "1.2.3.0/24", "34.64.0.0/10", "34.64.160.0/19", "34.64.161.0/24",
+		"2600:1900::/28", "2600:1900:4000::/40",
+	}, all)
+}
+
+func TestIndex(t *testing.T) {
+	r := require.New(t)
+
+	rnd := rand.New(rand.NewSource(3))
+
+	var entries []interval.Entry[int]
+	for i := 0; i < 2000; i++ {
+		low := rnd.Uint32()
+		high := low + uint32(rnd.Intn(1<<(8+rnd.Intn(16))))
+		if high < low {
+			high = low
+		}
+
+		in, err := interval.NewInterval(iputil.Long2IP(low), iputil.Long2IP(high))
+		r.NoError(err)
+		entries = append(entries, interval.Entry[int]{Interval: in, Payload: i})
+	}
+
+	tree := interval.Build(entries)
+	idx := NewIndex(entries)
+	r.Equal(tree.Len(), idx.Len())
+
+	var ips []uint32
+	for i := 0; i < 5000; i++ {
+		ip := rnd.Uint32()
+		if i%2 == 0 {
+			// Make sure to hit a range.
+			e := entries[rnd.Intn(len(entries))]
+			ip = e.Interval.Start() + uint32(rnd.Int63n(int64(e.Interval.Size())))
+		}
+		ips = append(ips, ip)
+
+		search, err := interval.NewInterval(iputil.Long2IP(ip), iputil.Long2IP(ip+uint32(rnd.Intn(1<<10))))
+		if err != nil {
+			continue
+		}
+
+		want, wantErr := tree.FindAllOverlapping(search)
+		got, gotErr := idx.FindAllOverlapping(search)
+		r.Equal(wantErr == nil, gotErr == nil)
+		r.Equal(want, got)
+
+		first, err := idx.FindFirstOverlapping(search)
+		if wantErr != nil {
+			r.Error(err)
+		} else {
+			r.NoError(err)
+			r.Equal(want[0], first)
+		}
+	}
+
+	r.Equal(tree.LookupBatch(ips), idx.LookupBatch(ips))
+}
+
+func BenchmarkLongestPrefix(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+
+	var trie Trie[int]
+	for i := 0; i < 1_000_000; i++ {
+		ip := rnd.Uint32()
+		trie.Insert(netip.PrefixFrom(addrFrom4(ip), 16+rnd.Intn(17)), i)
+	}
+
+	ips := make([]netip.Addr, 1024)
+	for i := range ips {
+		ips[i] = addrFrom4(rnd.Uint32())
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.LongestPrefix(ips[i%len(ips)])
+	}
+}